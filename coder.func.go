@@ -0,0 +1,95 @@
+package luaconv
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// decodeLuaFunc synthesizes a Go function of type `fntype` that calls the Lua function `fn`.  The Go
+// arguments are converted to Lua values with `encode`, and the Lua return values are converted back
+// to the Go function's return types with `decode`.  If the last return type is `error`, any Lua error
+// (or conversion failure) is returned through it.  Otherwise, it is raised as a panic.
+//
+// Like the LState it closes over, the resulting function must not be called concurrently.
+func decodeLuaFunc(L *lua.LState, fn *lua.LFunction, fntype reflect.Type, encode EncodeFunc, decode DecodeFunc) (reflect.Value, error) {
+	if L == nil {
+		return reflect.Value{}, errors.New("a *lua.LState is required to call Lua functions")
+	}
+
+	numOut := fntype.NumOut()
+	returnsError := numOut > 0 && fntype.Out(numOut-1) == errorType
+
+	numLuaOut := numOut
+	if returnsError {
+		numLuaOut = numOut - 1
+	}
+
+	fail := func(err error) []reflect.Value {
+		if !returnsError {
+			panic(err)
+		}
+
+		rets := make([]reflect.Value, numOut)
+		for i := 0; i < numLuaOut; i++ {
+			rets[i] = reflect.Zero(fntype.Out(i))
+		}
+		rets[numOut-1] = reflect.ValueOf(&err).Elem()
+		return rets
+	}
+
+	gofn := reflect.MakeFunc(fntype, func(args []reflect.Value) []reflect.Value {
+		// the variadic parameter arrives as a slice, but is passed to Lua as individual args
+		if fntype.IsVariadic() {
+			varargs := args[len(args)-1]
+			args = args[:len(args)-1]
+			for i := 0; i < varargs.Len(); i++ {
+				args = append(args, varargs.Index(i))
+			}
+		}
+
+		luaArgs := make([]lua.LValue, len(args))
+		for i := range args {
+			luaArg, err := encode(L, args[i])
+			if err != nil {
+				return fail(err)
+			}
+			luaArgs[i] = luaArg
+		}
+
+		err := L.CallByParam(lua.P{Fn: fn, NRet: numLuaOut, Protect: true}, luaArgs...)
+		if err != nil {
+			return fail(err)
+		}
+
+		luaRets := make([]lua.LValue, numLuaOut)
+		for i := numLuaOut - 1; i >= 0; i-- {
+			luaRets[i] = L.Get(-1)
+			L.Pop(1)
+		}
+
+		rets := make([]reflect.Value, numOut)
+		for i := range luaRets {
+			ret, err := decode(L, luaRets[i], fntype.Out(i))
+			if err != nil {
+				return fail(err)
+			}
+
+			// MakeFunc requires the exact return types, so e.g. a string decoded for an
+			// interface{} return value has to be boxed
+			rets[i] = reflect.New(fntype.Out(i)).Elem()
+			if ret.IsValid() {
+				rets[i].Set(ret)
+			}
+		}
+
+		if returnsError {
+			rets[numOut-1] = reflect.Zero(errorType)
+		}
+
+		return rets
+	})
+
+	return gofn, nil
+}
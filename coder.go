@@ -1,25 +1,144 @@
 package luaconv
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/yuin/gopher-lua"
 )
 
+// Encode converts a Go value into a Lua value using DefaultConverter.
 func Encode(L *lua.LState, nvval reflect.Value) (lua.LValue, error) {
+	return DefaultConverter.Encode(L, nvval)
+}
+
+// Encode converts a Go value into a Lua value, copying structs, maps, slices and arrays into Lua
+// tables.
+//
+// Maps and slices that are referenced more than once are encoded as a single table, so Lua sees the
+// same identity that Go does, and cyclic values do not recurse forever.
+func (c *Converter) Encode(L *lua.LState, nvval reflect.Value) (lv lua.LValue, err error) {
+	defer c.recoverPanic("Encode", &err)
+
+	return c.newEncoder(L).encode(nvval)
+}
+
+// encoder holds the state of a single call to Encode or StructCoder.StructToTable.
+type encoder struct {
+	c *Converter
+	L *lua.LState
+
+	// the tables created so far for maps and slices, so that values referenced more than once are
+	// encoded as the same table
+	seen map[encodeRef]*lua.LTable
+}
+
+// encodeRef identifies a map, slice or pointer by its underlying data.  Slices of different lengths or types
+// that share an array are distinct values.
+type encodeRef struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+func (c *Converter) newEncoder(L *lua.LState) *encoder {
+	return &encoder{c: c, L: L, seen: map[encodeRef]*lua.LTable{}}
+}
+
+// lookup returns the table already created for the map, slice or pointer `rval`, if there is one, along with
+// the encodeRef to pass to remember if there isn't.
+func (e *encoder) lookup(rval reflect.Value) (*lua.LTable, encodeRef) {
+	ref := encodeRef{ptr: rval.Pointer(), typ: rval.Type()}
+	if rval.Kind() == reflect.Slice {
+		ref.len = rval.Len()
+
+		// empty slices, and slices of zero-size elements, all point at the same address in the
+		// runtime, so their pointers say nothing about their identity
+		if ref.len == 0 || rval.Type().Elem().Size() == 0 {
+			ref.ptr = 0
+		}
+	} else if rval.Kind() == reflect.Ptr && rval.Type().Elem().Size() == 0 {
+		// ...and so do pointers to zero-size values
+		ref.ptr = 0
+	}
+
+	if ref.ptr == 0 {
+		return nil, ref
+	}
+	return e.seen[ref], ref
+}
+
+// remember records the table created for a map, slice or pointer.  It must be called before encoding the
+// value's contents, which may refer back to it.
+func (e *encoder) remember(ref encodeRef, table *lua.LTable) {
+	// nil maps and slices (and those that lookup can't identify) have no identity to preserve
+	if ref.ptr != 0 {
+		e.seen[ref] = table
+	}
+}
+
+func (e *encoder) encode(nvval reflect.Value) (lua.LValue, error) {
+	c, L := e.c, e.L
+
 	if !nvval.IsValid() {
 		return lua.LNil, nil
 	}
 
 	nvtype := nvval.Type()
 
+	if encode := c.encoderFor(nvtype); encode != nil {
+		return encode(L, nvval)
+	}
+
+	if lv, is, err := marshalLua(L, nvval); is {
+		return lv, err
+	}
+
+	if lv, is, err := marshalText(nvval); is {
+		return lv, err
+	}
+
 	switch nvtype.Kind() {
 	case reflect.Invalid:
 		return lua.LNil, nil
 
 	case reflect.Interface:
-		return Encode(L, nvval.Elem())
+		return e.encode(nvval.Elem())
+
+	case reflect.Ptr:
+		if nvval.IsNil() {
+			return lua.LNil, nil
+		}
+
+		// pointers to structs and arrays whose targets are encoded as tables are tracked like maps
+		// and slices, since the targets may refer back to them
+		elemType := nvtype.Elem()
+		isTable := elemType.Kind() == reflect.Struct ||
+			(elemType.Kind() == reflect.Array && elemType.Elem().Kind() != reflect.Uint8)
+
+		if !isTable || c.hasCustomEncoding(elemType) {
+			return e.encode(nvval.Elem())
+		}
+
+		table, ref := e.lookup(nvval)
+		if table != nil {
+			return table, nil
+		}
+
+		table = L.NewTable()
+		e.remember(ref, table)
+
+		if elemType.Kind() == reflect.Array {
+			return e.encodeElems(nvval.Elem(), table)
+		}
+		if err := c.NewStructCoder(elemType).fillTable(e, nvval.Elem(), table); err != nil {
+			return nil, err
+		}
+		return table, nil
 
 	case reflect.Bool:
 		return lua.LBool(nvval.Bool()), nil
@@ -29,14 +148,14 @@ func Encode(L *lua.LState, nvval reflect.Value) (lua.LValue, error) {
 		reflect.Int16,
 		reflect.Int32,
 		reflect.Int64:
-		return lua.LNumber(nvval.Int()), nil
+		return c.encodeInt(nvval.Int()), nil
 
 	case reflect.Uint,
 		reflect.Uint8,
 		reflect.Uint16,
 		reflect.Uint32,
 		reflect.Uint64:
-		return lua.LNumber(nvval.Uint()), nil
+		return c.encodeUint(nvval.Uint()), nil
 
 	case reflect.Float32, reflect.Float64:
 		return lua.LNumber(nvval.Float()), nil
@@ -45,40 +164,56 @@ func Encode(L *lua.LState, nvval reflect.Value) (lua.LValue, error) {
 		return lua.LString(nvval.String()), nil
 
 	case reflect.Complex64, reflect.Complex128:
-		return nil, fmt.Errorf("luaconv.Encode: cannot convert complex64/128 to lua value", nvtype.String())
+		cplx := nvval.Complex()
+		table := L.NewTable()
+		table.RawSetString("re", lua.LNumber(real(cplx)))
+		table.RawSetString("im", lua.LNumber(imag(cplx)))
+		return table, nil
 
 	case reflect.Slice, reflect.Array:
-		table := L.NewTable()
-		for i := 0; i < nvval.Len(); i++ {
-			elem := nvval.Index(i)
-			luaElem, err := Encode(L, elem)
-			if err != nil {
-				return nil, err
-			}
+		// Lua strings are 8-bit clean, so byte sequences are encoded as strings
+		if nvtype.Elem().Kind() == reflect.Uint8 {
+			return lua.LString(goBytes(nvval)), nil
+		}
 
-			table.RawSetInt(i+1, luaElem)
+		// arrays are values, so each one gets its own table
+		if nvtype.Kind() == reflect.Array {
+			return e.encodeElems(nvval, L.NewTable())
 		}
 
-		return table, nil
+		table, ref := e.lookup(nvval)
+		if table != nil {
+			return table, nil
+		}
+
+		table = L.NewTable()
+		e.remember(ref, table)
+		return e.encodeElems(nvval, table)
 
 	case reflect.Struct:
-		coder := NewStructCoder(nvtype)
-		return coder.StructToTable(L, nvval)
+		coder := c.NewStructCoder(nvtype)
+		return coder.structToTable(e, nvval)
 
 	case reflect.Map:
-		table := L.NewTable()
+		table, ref := e.lookup(nvval)
+		if table != nil {
+			return table, nil
+		}
+
+		table = L.NewTable()
+		e.remember(ref, table)
 
 		mapKeys := nvval.MapKeys()
 		for i := 0; i < len(mapKeys); i++ {
 			key := mapKeys[i]
 			val := nvval.MapIndex(key)
 
-			luaKey, err := Encode(L, key)
+			luaKey, err := e.encode(key)
 			if err != nil {
 				return nil, err
 			}
 
-			luaVal, err := Encode(L, val)
+			luaVal, err := e.encode(val)
 			if err != nil {
 				return nil, err
 			}
@@ -96,74 +231,306 @@ func Encode(L *lua.LState, nvval reflect.Value) (lua.LValue, error) {
 	}
 }
 
+// encodeElems encodes the elements of a slice or array into `table`.
+func (e *encoder) encodeElems(nvval reflect.Value, table *lua.LTable) (lua.LValue, error) {
+	for i := 0; i < nvval.Len(); i++ {
+		elem := nvval.Index(i)
+		luaElem, err := e.encode(elem)
+		if err != nil {
+			return nil, err
+		}
+
+		table.RawSetInt(i+1, luaElem)
+	}
+
+	return table, nil
+}
+
+// maxExactInt is the largest magnitude below which every integer can be represented exactly by a
+// float64, and therefore by an LNumber.
+const maxExactInt = 1 << 53
+
+func (c *Converter) encodeInt(i int64) lua.LValue {
+	if c.LargeIntsAsStrings && (i > maxExactInt || i < -maxExactInt) {
+		return lua.LString(strconv.FormatInt(i, 10))
+	}
+	return lua.LNumber(i)
+}
+
+func (c *Converter) encodeUint(u uint64) lua.LValue {
+	if c.LargeIntsAsStrings && u > maxExactInt {
+		return lua.LString(strconv.FormatUint(u, 10))
+	}
+	return lua.LNumber(u)
+}
+
 var (
 	stringType = reflect.TypeOf("")
 	numberType = reflect.TypeOf(float64(0))
 	boolType   = reflect.TypeOf(false)
 	sliceType  = reflect.TypeOf([]interface{}{})
 	mapType    = reflect.TypeOf(map[string]interface{}{})
+	anyMapType = reflect.TypeOf(map[interface{}]interface{}{})
 )
 
-func Decode(lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
+// Decode converts a Lua value into a Go value of type `destType` using DefaultConverter.
+func Decode(L *lua.LState, lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
+	return DefaultConverter.Decode(L, lv, destType)
+}
+
+// Decode converts a Lua value into a Go value of type `destType`, copying the contents of Lua tables
+// into new structs, maps, slices and arrays.  Failures are reported as a *ConversionError, and Go
+// panics as a *PanicError.
+func (c *Converter) Decode(L *lua.LState, lv lua.LValue, destType reflect.Type) (rval reflect.Value, err error) {
+	defer c.recoverPanic("Decode", &err)
+
+	d := c.newDecoder(L, "Decode")
+	rval, err = d.decode(lv, destType)
+	return rval, d.result(err)
+}
+
+// decoder holds the state of a single call to Decode, Unwrap or StructCoder.TableToStruct, which only
+// differ in how they convert the arguments and return values of Lua functions.
+type decoder struct {
+	c  *Converter
+	L  *lua.LState
+	op string // the name of the public entry point, for error messages
+
+	// the path from the top-level Lua value to the one currently being decoded
+	path []string
+
+	// in CollectErrors mode, the failures encountered so far
+	errs ConversionErrors
+
+	// the number of table entries decoded so far, for DecodeOptions.MaxElements
+	elements int
+
+	// whether numbers are checked as in DecodeOptions.StrictNumbers
+	strictNumbers bool
+
+	// whether Lua functions decoded into Go funcs Wrap and Unwrap their arguments and results, as
+	// for Unwrap, rather than Encode and Decode them
+	wrapFuncs bool
+
+	// the pointers, maps and slices created so far for Lua tables, so that a table referenced more
+	// than once is decoded as the same Go value
+	seen map[decodeRef]reflect.Value
+}
+
+// decodeRef identifies the Go value created for a Lua table.  The same table may be decoded into
+// several different types.
+type decodeRef struct {
+	table *lua.LTable
+	typ   reflect.Type
+}
+
+func (c *Converter) newDecoder(L *lua.LState, op string) *decoder {
+	return &decoder{
+		c:             c,
+		L:             L,
+		op:            op,
+		seen:          map[decodeRef]reflect.Value{},
+		strictNumbers: c.DecodeOptions.StrictNumbers,
+	}
+}
+
+// lookup returns the Go value already created for `lv` as a `destType`, if `lv` is a table that has
+// been decoded into that type before.
+func (d *decoder) lookup(lv lua.LValue, destType reflect.Type) (reflect.Value, bool) {
+	table, is := lv.(*lua.LTable)
+	if !is {
+		return reflect.Value{}, false
+	}
+
+	rval, is := d.seen[decodeRef{table, destType}]
+	return rval, is
+}
+
+// remember records the Go value created for a Lua table.  It must be called before decoding the
+// table's contents, which may refer back to it.
+func (d *decoder) remember(table *lua.LTable, rval reflect.Value) {
+	d.seen[decodeRef{table, rval.Type()}] = rval
+}
+
+// fail returns a *ConversionError for the Lua value at the current path.  If `cause` is already a
+// *ConversionError (from a nested value), it is returned unchanged.
+func (d *decoder) fail(lv lua.LValue, destType reflect.Type, cause error) error {
+	if err, is := cause.(*ConversionError); is {
+		return err
+	}
+
+	return &ConversionError{
+		Op:      d.op,
+		Path:    formatPath(d.path),
+		LuaType: luaTypeName(lv),
+		GoType:  destType,
+		Err:     cause,
+	}
+}
+
+// collect records the failure of a nested value so that the caller can skip over it and continue.
+// It returns false if decoding should stop instead, i.e., when not in CollectErrors mode.
+func (d *decoder) collect(err error) bool {
+	if !d.c.DecodeOptions.CollectErrors {
+		return false
+	}
+
+	convErr, is := err.(*ConversionError)
+	if !is {
+		return false
+	} else if _, is := convErr.Err.(*LimitError); is {
+		return false
+	}
+
+	d.errs = append(d.errs, convErr)
+	return true
+}
+
+// result returns the error for the top-level call, which in CollectErrors mode includes every
+// failure collected along the way.
+func (d *decoder) result(err error) error {
+	if err != nil && !d.collect(err) {
+		return err
+	} else if len(d.errs) > 0 {
+		return d.errs
+	}
+	return nil
+}
+
+// decodeAt decodes a value nested within the current one under the Lua table key `key`.
+func (d *decoder) decodeAt(key lua.LValue, lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
+	d.path = append(d.path, pathSegment(key))
+	defer func() { d.path = d.path[:len(d.path)-1] }()
+
+	return d.decode(lv, destType)
+}
+
+// checkLimits enforces DecodeOptions.MaxDepth and DecodeOptions.MaxStringLen for a value that is
+// about to be decoded.
+func (d *decoder) checkLimits(lv lua.LValue, destType reflect.Type) error {
+	opts := d.c.DecodeOptions
+
+	switch lv := lv.(type) {
+	case lua.LString:
+		if opts.MaxStringLen > 0 && len(lv) > opts.MaxStringLen {
+			return d.fail(lv, destType, &LimitError{Limit: "MaxStringLen", Max: opts.MaxStringLen})
+		}
+
+	case *lua.LTable:
+		if opts.MaxDepth > 0 && len(d.path)+1 > opts.MaxDepth {
+			return d.fail(lv, destType, &LimitError{Limit: "MaxDepth", Max: opts.MaxDepth})
+		}
+	}
+	return nil
+}
+
+// countElements enforces DecodeOptions.MaxElements before `n` more table entries are decoded.
+func (d *decoder) countElements(table *lua.LTable, destType reflect.Type, n int) error {
+	d.elements += n
+
+	if max := d.c.DecodeOptions.MaxElements; max > 0 && d.elements > max {
+		return d.fail(table, destType, &LimitError{Limit: "MaxElements", Max: max})
+	}
+	return nil
+}
+
+func (d *decoder) decode(lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
+	if err := d.checkLimits(lv, destType); err != nil {
+		return reflect.Value{}, err
+	}
+
 	// special handling for lua UserData values
 	if ud, is := lv.(*lua.LUserData); is {
-		rval := ud.Value.(reflect.Value)
-		rtype := rval.Type()
+		if rval, is := ud.Value.(reflect.Value); is {
+			rtype := rval.Type()
+
+			if rtype == destType {
+				return rval, nil
+			} else if rtype.ConvertibleTo(destType) {
+				return rval.Convert(destType), nil
+			} else if destType == reflect.PtrTo(rtype) && rval.CanAddr() {
+				return rval.Addr(), nil
+			} else if rtype.Kind() == reflect.Ptr && rtype.Elem() == destType && !rval.IsNil() {
+				return rval.Elem(), nil
+			}
+		}
+	}
 
-		if rtype == destType {
-			return rval, nil
-		} else if rtype.ConvertibleTo(destType) {
-			return rval.Convert(destType), nil
-		} else if destType == reflect.PtrTo(rtype) && rval.CanAddr() {
-			return rval.Addr(), nil
-		} else {
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert userdata(%v) to %v", rtype, destType.String())
+	// nil decodes to the zero value without consulting custom decoders, which would otherwise all
+	// have to handle it themselves
+	if lv == lua.LNil {
+		return d.decodeNil(destType)
+	}
+
+	if decode := d.c.decoderFor(destType); decode != nil {
+		rval, err := decode(d.L, lv, destType)
+		if err != nil {
+			return reflect.Value{}, d.fail(lv, destType, err)
+		}
+		return rval, nil
+	}
+
+	if rval, is, err := unmarshalLua(lv, destType); is {
+		if err != nil {
+			return reflect.Value{}, d.fail(lv, destType, err)
+		}
+		return rval, nil
+	}
+
+	if rval, is, err := unmarshalText(lv, destType); is {
+		if err != nil {
+			return reflect.Value{}, d.fail(lv, destType, err)
 		}
+		return rval, nil
+	}
+
+	if _, is := lv.(*lua.LUserData); is {
+		return reflect.Value{}, d.fail(lv, destType, nil)
 	}
 
 	switch destType.Kind() {
 	case reflect.Interface:
 		switch lv := lv.(type) {
 		case lua.LString:
-			return Decode(lv, stringType)
+			return d.decode(lv, stringType)
 		case lua.LNumber:
-			return Decode(lv, numberType)
+			return d.decode(lv, numberType)
 		case lua.LBool:
-			return Decode(lv, boolType)
+			return d.decode(lv, boolType)
 		case *lua.LTable:
-			if lv.MaxN() > 0 {
-				return Decode(lv, sliceType)
-			} else {
-				return Decode(lv, mapType)
-			}
+			return d.decode(lv, d.interfaceTypeFor(lv))
 		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			return reflect.Value{}, d.fail(lv, destType, nil)
 		}
 
 	case reflect.Bool:
 		switch lv := lv.(type) {
 		case lua.LBool:
-			return reflect.ValueOf(bool(lv)), nil
+			return reflect.ValueOf(bool(lv)).Convert(destType), nil
+
+		case lua.LNumber:
+			if d.c.DecodeOptions.WeaklyTyped {
+				return reflect.ValueOf(lv != 0).Convert(destType), nil
+			}
+			return reflect.Value{}, d.fail(lv, destType, nil)
 
 		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			return reflect.Value{}, d.fail(lv, destType, nil)
 		}
 
 	case reflect.String:
 		switch lv := lv.(type) {
 		case lua.LString:
-			strval := reflect.ValueOf(string(lv))
+			return reflect.ValueOf(string(lv)).Convert(destType), nil
 
-			if strval.Type() == destType {
-				return strval, nil
-			} else if strval.Type().ConvertibleTo(destType) {
-				return strval.Convert(destType), nil
-			} else {
-				return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+		case lua.LNumber:
+			if d.c.DecodeOptions.WeaklyTyped {
+				return reflect.ValueOf(lv.String()).Convert(destType), nil
 			}
+			return reflect.Value{}, d.fail(lv, destType, nil)
 
 		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			return reflect.Value{}, d.fail(lv, destType, nil)
 		}
 
 	case reflect.Int,
@@ -181,28 +548,123 @@ func Decode(lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
 
 		switch lv := lv.(type) {
 		case lua.LNumber:
-			return reflect.ValueOf(float64(lv)).Convert(destType), nil
+			return d.decodeNumber(lv, destType)
+		case lua.LString:
+			if d.c.LargeIntsAsStrings && isIntKind(destType.Kind()) {
+				// strings that aren't plain decimal integers may still be numbers in WeaklyTyped mode
+				rval, err := d.decodeIntString(lv, destType)
+				if err == nil || !d.c.DecodeOptions.WeaklyTyped {
+					return rval, err
+				}
+			}
+
+			if d.c.DecodeOptions.WeaklyTyped {
+				return d.decodeNumberString(lv, destType)
+			}
+			return reflect.Value{}, d.fail(lv, destType, nil)
+		case lua.LBool:
+			if d.c.DecodeOptions.WeaklyTyped {
+				if lv {
+					return d.decodeNumber(1, destType)
+				}
+				return d.decodeNumber(0, destType)
+			}
+			return reflect.Value{}, d.fail(lv, destType, nil)
 		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			return reflect.Value{}, d.fail(lv, destType, nil)
 		}
 
 	case reflect.Complex64, reflect.Complex128:
-		return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert to/from complex64 or complex128")
+		switch lv := lv.(type) {
+		case lua.LNumber:
+			return reflect.ValueOf(complex(float64(lv), 0)).Convert(destType), nil
+
+		case *lua.LTable:
+			// the table must look like {re=..., im=...}, although either part (but not both) may be
+			// omitted
+			var badKey lua.LValue
+			lv.ForEach(func(key, _ lua.LValue) {
+				if key != lua.LString("re") && key != lua.LString("im") && badKey == nil {
+					badKey = key
+				}
+			})
+			if badKey != nil {
+				return reflect.Value{}, d.fail(lv, destType, fmt.Errorf("unexpected key %v", formatPath([]string{pathSegment(badKey)})))
+			}
+
+			var parts [2]float64
+			var found bool
+			for i, key := range []string{"re", "im"} {
+				part := lv.RawGetString(key)
+				if num, is := part.(lua.LNumber); is {
+					parts[i], found = float64(num), true
+				} else if part != lua.LNil {
+					return reflect.Value{}, d.fail(lv, destType, fmt.Errorf("field '%v' must be a number", key))
+				}
+			}
+			if !found {
+				return reflect.Value{}, d.fail(lv, destType, errors.New("table has neither 're' nor 'im'"))
+			}
+			return reflect.ValueOf(complex(parts[0], parts[1])).Convert(destType), nil
+
+		default:
+			return reflect.Value{}, d.fail(lv, destType, nil)
+		}
+
+	case reflect.Ptr:
+		if ptr, is := d.lookup(lv, destType); is {
+			return ptr, nil
+		}
+
+		ptr := reflect.New(destType.Elem())
+		if table, is := lv.(*lua.LTable); is {
+			d.remember(table, ptr)
+		}
+
+		elem, err := d.decode(lv, destType.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		ptr.Elem().Set(elem)
+		return ptr, nil
 
 	case reflect.Slice:
+		if str, is := lv.(lua.LString); is && destType.Elem().Kind() == reflect.Uint8 {
+			return decodeByteSlice(str, destType), nil
+		}
+
 		table, is := lv.(*lua.LTable)
 		if !is {
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			if d.c.DecodeOptions.WeaklyTyped && isLuaScalar(lv) {
+				return d.decodeScalarSlice(lv, destType)
+			}
+			return reflect.Value{}, d.fail(lv, destType, nil)
+		}
+
+		if slice, is := d.lookup(table, destType); is {
+			return slice, nil
+		}
+
+		maxn, err := d.arrayLen(table, destType)
+		if err != nil {
+			return reflect.Value{}, err
+		} else if err := d.countElements(table, destType, maxn); err != nil {
+			return reflect.Value{}, err
 		}
 
-		maxn := table.MaxN()
 		slice := reflect.MakeSlice(destType, maxn, maxn)
+		d.remember(table, slice)
 
 		for i := 0; i < maxn; i++ {
-			luaVal := table.RawGet(lua.LNumber(i + 1))
+			luaKey := lua.LNumber(i + 1)
+			luaVal := table.RawGet(luaKey)
 
-			x, err := Decode(luaVal, destType.Elem())
+			x, err := d.decodeAt(luaKey, luaVal, destType.Elem())
 			if err != nil {
+				if d.collect(err) {
+					continue
+				}
 				return reflect.Value{}, err
 			}
 
@@ -212,19 +674,42 @@ func Decode(lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
 		return slice, nil
 
 	case reflect.Array:
+		if str, is := lv.(lua.LString); is && destType.Elem().Kind() == reflect.Uint8 {
+			n, err := d.fitArray(lv, destType, len(str))
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return decodeByteArray(str[:n], destType), nil
+		}
+
 		table, is := lv.(*lua.LTable)
 		if !is {
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			return reflect.Value{}, d.fail(lv, destType, nil)
+		}
+
+		maxn, err := d.arrayLen(table, destType)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		maxn, err = d.fitArray(table, destType, maxn)
+		if err != nil {
+			return reflect.Value{}, err
+		} else if err := d.countElements(table, destType, maxn); err != nil {
+			return reflect.Value{}, err
 		}
 
-		maxn := table.MaxN()
 		array := reflect.New(destType).Elem()
 
 		for i := 0; i < maxn; i++ {
-			luaVal := table.RawGet(lua.LNumber(i + 1))
+			luaKey := lua.LNumber(i + 1)
+			luaVal := table.RawGet(luaKey)
 
-			x, err := Decode(luaVal, destType.Elem())
+			x, err := d.decodeAt(luaKey, luaVal, destType.Elem())
 			if err != nil {
+				if d.collect(err) {
+					continue
+				}
 				return reflect.Value{}, err
 			}
 
@@ -236,22 +721,38 @@ func Decode(lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
 	case reflect.Map:
 		table, is := lv.(*lua.LTable)
 		if !is {
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			return reflect.Value{}, d.fail(lv, destType, nil)
+		}
+
+		if aMap, is := d.lookup(table, destType); is {
+			return aMap, nil
 		}
 
 		aMap := reflect.MakeMap(destType)
+		d.remember(table, aMap)
+
+		if err := d.countElements(table, destType, countLuaTableEntries(table)); err != nil {
+			return reflect.Value{}, err
+		}
 		tableData := getLuaTableData(table)
+
 		destTypeKey := destType.Key()
 		destTypeElem := destType.Elem()
 
 		for _, x := range tableData {
-			nvkey, err := Decode(x.key, destTypeKey)
+			nvkey, err := d.decodeAt(x.key, x.key, destTypeKey)
 			if err != nil {
+				if d.collect(err) {
+					continue
+				}
 				return reflect.Value{}, err
 			}
 
-			nvval, err := Decode(x.val, destTypeElem)
+			nvval, err := d.decodeAt(x.key, x.val, destTypeElem)
 			if err != nil {
+				if d.collect(err) {
+					continue
+				}
 				return reflect.Value{}, err
 			}
 
@@ -263,26 +764,255 @@ func Decode(lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
 	case reflect.Struct:
 		switch lv := lv.(type) {
 		case *lua.LTable:
-			coder := NewStructCoder(destType)
-			aStruct, err := coder.TableToStruct(lv)
+			coder := d.c.NewStructCoder(destType)
+			aStruct, err := coder.tableToStruct(d, lv)
 			if err != nil {
 				return reflect.Value{}, err
 			}
 			return reflect.ValueOf(aStruct), nil
 
 		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			return reflect.Value{}, d.fail(lv, destType, nil)
 		}
 
 	case reflect.Func:
 		switch lv := lv.(type) {
-		case *lua.LUserData:
-			return lv.Value.(reflect.Value), nil
+		case *lua.LFunction:
+			var fnval reflect.Value
+			var err error
+			if d.wrapFuncs {
+				fnval, err = decodeLuaFunc(d.L, lv, destType, d.c.Wrap, d.c.Unwrap)
+			} else {
+				fnval, err = decodeLuaFunc(d.L, lv, destType, d.c.Encode, d.c.Decode)
+			}
+			if err != nil {
+				return reflect.Value{}, d.fail(lv, destType, err)
+			}
+			return fnval, nil
+
+		default:
+			return reflect.Value{}, d.fail(lv, destType, nil)
+		}
+
+	default:
+		return reflect.Value{}, d.fail(lv, destType, nil)
+	}
+}
+
+// interfaceTypeFor returns the type that a table is decoded into when the destination type is an
+// interface, according to DecodeOptions.TableShape.
+func (d *decoder) interfaceTypeFor(table *lua.LTable) reflect.Type {
+	shape := d.c.DecodeOptions.TableShape
+
+	if shape == TableShapeSequence {
+		if table.MaxN() > 0 {
+			return sliceType
+		}
+		return mapType
+	}
+
+	n, others := measureArray(table, shape)
+	if n > 0 && !others {
+		return sliceType
+	} else if hasOnlyStringKeys(table) {
+		return mapType
+	}
+	return anyMapType
+}
+
+// arrayLen returns the number of elements of a table that are decoded into a slice or array,
+// according to DecodeOptions.TableShape.  In StrictArrays mode, it fails if the table has other keys.
+func (d *decoder) arrayLen(table *lua.LTable, destType reflect.Type) (int, error) {
+	opts := d.c.DecodeOptions
+
+	if opts.TableShape == TableShapeSequence && !opts.StrictArrays {
+		return table.MaxN(), nil
+	}
+
+	n, others := measureArray(table, opts.TableShape)
+	if others && opts.StrictArrays {
+		return 0, d.fail(table, destType, errors.New("table has keys outside of its array part"))
+	}
+	return n, nil
+}
+
+// fitArray applies DecodeOptions.ArrayLength to a Lua value with `n` elements that is being decoded
+// into the array type `destType`, and returns the number of elements to decode.
+func (d *decoder) fitArray(lv lua.LValue, destType reflect.Type, n int) (int, error) {
+	policy := d.c.DecodeOptions.ArrayLength
+
+	switch {
+	case n > destType.Len() && policy == ArrayLengthTruncate:
+		return destType.Len(), nil
+	case n > destType.Len():
+		return 0, d.fail(lv, destType, fmt.Errorf("length %v is greater than %v", n, destType.Len()))
+	case n < destType.Len() && policy == ArrayLengthExact:
+		return 0, d.fail(lv, destType, fmt.Errorf("length %v is less than %v", n, destType.Len()))
+	default:
+		return n, nil
+	}
+}
+
+// decodeNumber converts an LNumber into destType, which must be an integer or float kind.  In
+// StrictNumbers mode, it refuses to truncate fractions or overflow the destination type.
+func (d *decoder) decodeNumber(lv lua.LNumber, destType reflect.Type) (reflect.Value, error) {
+	f := float64(lv)
+
+	if d.strictNumbers {
+		// min is inclusive and max is exclusive, since e.g. 2^63 itself is not a valid int64
+		var min, max float64
+		switch destType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			min, max = math.Ldexp(-1, destType.Bits()-1), math.Ldexp(1, destType.Bits()-1)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			min, max = 0, math.Ldexp(1, destType.Bits())
+		case reflect.Float32:
+			if math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+				return reflect.Value{}, d.fail(lv, destType, fmt.Errorf("%v is out of range", lv))
+			}
+			return reflect.ValueOf(f).Convert(destType), nil
 		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+			return reflect.ValueOf(f).Convert(destType), nil
+		}
+
+		if f != math.Trunc(f) {
+			return reflect.Value{}, d.fail(lv, destType, fmt.Errorf("%v is not an integer", lv))
+		} else if f < min || f >= max {
+			return reflect.Value{}, d.fail(lv, destType, fmt.Errorf("%v is out of range", lv))
+		}
+	}
+
+	return reflect.ValueOf(f).Convert(destType), nil
+}
+
+// decodeIntString parses a decimal string, as produced in LargeIntsAsStrings mode, into destType,
+// which must be an integer kind.
+func (d *decoder) decodeIntString(lv lua.LString, destType reflect.Type) (reflect.Value, error) {
+	rval := reflect.New(destType).Elem()
+
+	switch destType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(string(lv), 10, destType.Bits())
+		if err != nil {
+			return reflect.Value{}, d.fail(lv, destType, err)
+		}
+		rval.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(string(lv), 10, destType.Bits())
+		if err != nil {
+			return reflect.Value{}, d.fail(lv, destType, err)
+		}
+		rval.SetUint(u)
+
+	default:
+		return reflect.Value{}, d.fail(lv, destType, nil)
+	}
+
+	return rval, nil
+}
+
+// decodeNumberString parses a string containing a number, in Lua's syntax, into destType, which must
+// be an integer or float kind.  Integers are parsed exactly if possible.
+func (d *decoder) decodeNumberString(lv lua.LString, destType reflect.Type) (reflect.Value, error) {
+	str := strings.TrimSpace(string(lv))
+
+	if isIntKind(destType.Kind()) {
+		if rval, err := d.decodeIntString(lua.LString(str), destType); err == nil {
+			return rval, nil
 		}
+	}
+
+	f, err := parseLuaNumber(str)
+	if err != nil {
+		return reflect.Value{}, d.fail(lv, destType, err)
+	} else if isIntKind(destType.Kind()) && (math.IsInf(f, 0) || math.IsNaN(f)) {
+		return reflect.Value{}, d.fail(lv, destType, fmt.Errorf("%v is out of range", lv))
+	}
+	return d.decodeNumber(lua.LNumber(f), destType)
+}
+
+// decodeScalarSlice decodes a single scalar Lua value into a one-element slice of type destType.
+func (d *decoder) decodeScalarSlice(lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
+	elem, err := d.decode(lv, destType.Elem())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	slice := reflect.MakeSlice(destType, 1, 1)
+	slice.Index(0).Set(elem)
+	return slice, nil
+}
+
+// decodeNil returns the zero value of destType, which is nil for pointers, interfaces, slices, maps,
+// funcs and chans.  In StrictNil mode, nil cannot be decoded into any other kind.
+func (d *decoder) decodeNil(destType reflect.Type) (reflect.Value, error) {
+	switch destType.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+		return reflect.Zero(destType), nil
+
+	default:
+		if d.c.DecodeOptions.StrictNil {
+			return reflect.Value{}, d.fail(lua.LNil, destType, nil)
+		}
+		return reflect.Zero(destType), nil
+	}
+}
+
+var byteType = reflect.TypeOf(byte(0))
+
+// goBytes copies the contents of a slice or array with a byte element kind into a []byte.
+func goBytes(v reflect.Value) []byte {
+	if v.Kind() == reflect.Slice && v.Type().Elem() == byteType {
+		return v.Bytes()
+	}
+
+	bs := make([]byte, v.Len())
+	for i := range bs {
+		bs[i] = byte(v.Index(i).Uint())
+	}
+	return bs
+}
+
+// decodeByteSlice copies a Lua string into a new slice of type destType, which must have a byte
+// element kind.
+func decodeByteSlice(str lua.LString, destType reflect.Type) reflect.Value {
+	if destType.Elem() == byteType {
+		return reflect.ValueOf([]byte(str)).Convert(destType)
+	}
+
+	slice := reflect.MakeSlice(destType, len(str), len(str))
+	for i := 0; i < len(str); i++ {
+		slice.Index(i).SetUint(uint64(str[i]))
+	}
+	return slice
+}
+
+// decodeByteArray copies a Lua string into a new array of type destType, which must have a byte
+// element kind and be at least as long as the string.  Any remaining elements are left zeroed.
+func decodeByteArray(str lua.LString, destType reflect.Type) reflect.Value {
+	array := reflect.New(destType).Elem()
+	for i := 0; i < len(str); i++ {
+		array.Index(i).SetUint(uint64(str[i]))
+	}
+	return array
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
 
+func isLuaScalar(lv lua.LValue) bool {
+	switch lv.(type) {
+	case lua.LString, lua.LNumber, lua.LBool:
+		return true
 	default:
-		return reflect.Value{}, fmt.Errorf("luaconv.Decode: cannot convert %v to %v", lv.Type(), destType.String())
+		return false
 	}
 }
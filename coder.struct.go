@@ -10,47 +10,90 @@ import (
 
 type (
 	StructCoder struct {
-		z *structomancer.Structomancer
+		z          *structomancer.Structomancer
+		structType reflect.Type
+		conv       *Converter
 	}
 )
 
+// NewStructCoder returns a StructCoder for `structType` that converts field values using
+// DefaultConverter.
 func NewStructCoder(structType reflect.Type) *StructCoder {
+	return DefaultConverter.NewStructCoder(structType)
+}
+
+// NewStructCoder returns a StructCoder for `structType` that converts field values using `c`.
+func (c *Converter) NewStructCoder(structType reflect.Type) *StructCoder {
 	return &StructCoder{
-		z: structomancer.NewWithType(structType, "lua"),
+		z:          structomancer.NewWithType(structType, "lua"),
+		structType: structType,
+		conv:       c,
+	}
+}
+
+func (c *StructCoder) StructToTable(L *lua.LState, aStruct interface{}) (table *lua.LTable, err error) {
+	defer c.conv.recoverPanic("StructCoder.StructToTable", &err)
+
+	return c.structToTable(c.conv.newEncoder(L), aStruct)
+}
+
+func (c *StructCoder) structToTable(e *encoder, aStruct interface{}) (*lua.LTable, error) {
+	table := e.L.NewTable()
+	if err := c.fillTable(e, aStruct, table); err != nil {
+		return nil, err
 	}
+	return table, nil
 }
 
-func (c *StructCoder) StructToTable(L *lua.LState, aStruct interface{}) (*lua.LTable, error) {
+// fillTable sets the fields of `aStruct` on an existing table.
+func (c *StructCoder) fillTable(e *encoder, aStruct interface{}, table *lua.LTable) error {
 	m, err := c.z.StructToMap(aStruct)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	table := L.NewTable()
 	for key, val := range m {
-		luaVal, err := Encode(L, reflect.ValueOf(val))
+		luaVal, err := e.encode(reflect.ValueOf(val))
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		table.RawSetString(key, luaVal)
 	}
 
-	return table, nil
+	return nil
 }
 
-func (c *StructCoder) TableToStruct(table *lua.LTable) (interface{}, error) {
+func (c *StructCoder) TableToStruct(L *lua.LState, table *lua.LTable) (aStruct interface{}, err error) {
+	defer c.conv.recoverPanic("StructCoder.TableToStruct", &err)
+
+	d := c.conv.newDecoder(L, "StructCoder.TableToStruct")
+	aStruct, err = c.tableToStruct(d, table)
+	return aStruct, d.result(err)
+}
+
+func (c *StructCoder) tableToStruct(d *decoder, table *lua.LTable) (interface{}, error) {
+	if err := d.countElements(table, c.structType, countLuaTableEntries(table)); err != nil {
+		return nil, err
+	}
 	tableData := getLuaTableData(table)
 
 	aMap := make(map[string]interface{}, len(tableData))
 	for _, x := range tableData {
 		key, is := x.key.(lua.LString)
 		if !is {
-			return nil, errors.New("luaconv.StructCoder.TableToStruct: cannot convert a table with non-string keys to a struct")
+			err := d.fail(table, c.structType, errors.New("table has non-string keys"))
+			if d.collect(err) {
+				continue
+			}
+			return nil, err
 		}
 
-		val, err := Decode(x.val, c.z.Field(string(key)).Type())
+		val, err := d.decodeAt(key, x.val, c.z.Field(string(key)).Type())
 		if err != nil {
+			if d.collect(err) {
+				continue
+			}
 			return nil, err
 		}
 
@@ -2,6 +2,7 @@ package luaconv_test
 
 import (
 	"reflect"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -60,7 +61,7 @@ var _ = Describe("StructCoder", func() {
 			table.RawSetString("name", lua.LString("bryn"))
 			table.RawSetString("color", lua.LNumber(123))
 
-			aStruct, err := coder.TableToStruct(table)
+			aStruct, err := coder.TableToStruct(L, table)
 			if err != nil {
 				Fail(err.Error())
 			}
@@ -82,6 +83,8 @@ var _ = Describe("Encode", func() {
 			}{
 				{"bryn", lua.LString("bryn")},
 				{uint32(123), lua.LNumber(123)},
+				{[]byte{0, 255, 'a'}, lua.LString("\x00\xffa")},
+				{[2]byte{'a', 'b'}, lua.LString("ab")},
 			}
 
 			for i := range tests {
@@ -95,6 +98,157 @@ var _ = Describe("Encode", func() {
 		})
 	})
 
+	Context("when given a Go complex number", func() {
+		It("should return a table with 're' and 'im' fields that decodes back to the same value", func() {
+			L := lua.NewState()
+
+			luaVal, err := luaconv.Encode(L, reflect.ValueOf(complex64(1.5-2i)))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			table := luaVal.(*lua.LTable)
+			Expect(table.RawGetString("re")).To(Equal(lua.LNumber(1.5)))
+			Expect(table.RawGetString("im")).To(Equal(lua.LNumber(-2)))
+
+			nv, err := luaconv.Decode(L, table, reflect.TypeOf(complex64(0)))
+			if err != nil {
+				Fail(err.Error())
+			}
+			Expect(nv.Interface()).To(Equal(complex64(1.5 - 2i)))
+		})
+	})
+
+	Context("when given maps and slices that are referenced more than once", func() {
+		It("should encode each of them as a single table", func() {
+			L := lua.NewState()
+
+			shared := []interface{}{"a", "b"}
+			graph := map[string]interface{}{"x": shared, "y": shared}
+			graph["self"] = graph
+
+			luaVal, err := luaconv.Encode(L, reflect.ValueOf(graph))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			table := luaVal.(*lua.LTable)
+			Expect(table.RawGetString("self")).To(BeIdenticalTo(table))
+			Expect(table.RawGetString("x")).To(BeIdenticalTo(table.RawGetString("y")))
+		})
+
+		It("should encode distinct empty slices as distinct tables", func() {
+			type twoSlices struct {
+				A []int      `lua:"a"`
+				B []int      `lua:"b"`
+				C []struct{} `lua:"c"`
+				D []struct{} `lua:"d"`
+			}
+
+			L := lua.NewState()
+
+			luaVal, err := luaconv.Encode(L, reflect.ValueOf(twoSlices{
+				A: []int{},
+				B: []int{},
+				C: []struct{}{{}},
+				D: []struct{}{{}},
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			table := luaVal.(*lua.LTable)
+			Expect(table.RawGetString("a")).NotTo(BeIdenticalTo(table.RawGetString("b")))
+			Expect(table.RawGetString("c")).NotTo(BeIdenticalTo(table.RawGetString("d")))
+		})
+
+		It("should encode subslices of the same array as distinct tables", func() {
+			L := lua.NewState()
+
+			all := []string{"a", "b", "c"}
+			luaVal, err := luaconv.Encode(L, reflect.ValueOf([][]string{all, all[:2]}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			table := luaVal.(*lua.LTable)
+			Expect(table.RawGetInt(1).(*lua.LTable).Len()).To(Equal(3))
+			Expect(table.RawGetInt(2).(*lua.LTable).Len()).To(Equal(2))
+		})
+	})
+
+	Context("when given pointers", func() {
+		type Item struct {
+			Name string `lua:"name"`
+		}
+
+		type Config struct {
+			Items   []*Item    `lua:"items"`
+			Default *Item      `lua:"default"`
+			Missing *Item      `lua:"missing"`
+			Updated *time.Time `lua:"updated"`
+		}
+
+		It("should encode their targets, and nil pointers as nil", func() {
+			L := lua.NewState()
+
+			updated := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+			item := &Item{Name: "a"}
+			config := &Config{Items: []*Item{item, {Name: "b"}}, Default: item, Updated: &updated}
+
+			luaVal, err := luaconv.Encode(L, reflect.ValueOf(config))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			table := luaVal.(*lua.LTable)
+			items := table.RawGetString("items").(*lua.LTable)
+			Expect(items.Len()).To(Equal(2))
+			Expect(items.RawGetInt(2).(*lua.LTable).RawGetString("name")).To(Equal(lua.LString("b")))
+			Expect(table.RawGetString("default")).To(BeIdenticalTo(items.RawGetInt(1)))
+			Expect(table.RawGetString("missing")).To(Equal(lua.LNil))
+			Expect(table.RawGetString("updated")).To(Equal(lua.LString("2016-01-02T03:04:05Z")))
+
+			luaVal, err = luaconv.Encode(L, reflect.ValueOf((*Config)(nil)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(luaVal).To(Equal(lua.LNil))
+		})
+
+		It("should encode distinct pointers to zero-size structs as distinct tables", func() {
+			type Empty struct{}
+
+			L := lua.NewState()
+
+			luaVal, err := luaconv.Encode(L, reflect.ValueOf([]*Empty{{}, {}}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			table := luaVal.(*lua.LTable)
+			Expect(table.RawGetInt(1)).NotTo(BeIdenticalTo(table.RawGetInt(2)))
+		})
+
+		It("should encode cyclic pointers as cyclic tables", func() {
+			type Node struct {
+				Next *Node `lua:"next"`
+			}
+
+			L := lua.NewState()
+
+			node := &Node{}
+			node.Next = &Node{Next: node}
+
+			luaVal, err := luaconv.Encode(L, reflect.ValueOf(node))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			table := luaVal.(*lua.LTable)
+			next := table.RawGetString("next").(*lua.LTable)
+			Expect(next.RawGetString("next")).To(BeIdenticalTo(table))
+		})
+	})
+
 	Context("when given a Go map with string indices", func() {
 		It("should return a Lua table", func() {
 			L := lua.NewState()
@@ -133,7 +287,7 @@ var _ = Describe("Decode", func() {
 			type Name string
 			nameType := reflect.TypeOf(Name(""))
 
-			nv, err := luaconv.Decode(lua.LString("bryn"), nameType)
+			nv, err := luaconv.Decode(nil, lua.LString("bryn"), nameType)
 			if err != nil {
 				Fail(err.Error())
 			}
@@ -147,7 +301,7 @@ var _ = Describe("Decode", func() {
 			type Age uint64
 			ageType := reflect.TypeOf(Age(0))
 
-			nv, err := luaconv.Decode(lua.LNumber(123), ageType)
+			nv, err := luaconv.Decode(nil, lua.LNumber(123), ageType)
 			if err != nil {
 				Fail(err.Error())
 			}
@@ -171,7 +325,7 @@ var _ = Describe("Decode", func() {
 			table.RawSetString("name", lua.LString("bryn"))
 			table.RawSetString("color", lua.LNumber(123))
 
-			nv, err := luaconv.Decode(table, reflect.TypeOf(Blah{}))
+			nv, err := luaconv.Decode(L, table, reflect.TypeOf(Blah{}))
 			if err != nil {
 				Fail(err.Error())
 			}
@@ -188,7 +342,7 @@ var _ = Describe("Decode", func() {
 			table.RawSetString("name", lua.LString("bryn"))
 			table.RawSetString("color", lua.LNumber(123))
 
-			nv, err := luaconv.Decode(table, reflect.TypeOf(map[string]interface{}{}))
+			nv, err := luaconv.Decode(L, table, reflect.TypeOf(map[string]interface{}{}))
 			if err != nil {
 				Fail(err.Error())
 			}
@@ -213,7 +367,7 @@ var _ = Describe("Decode", func() {
 			table.RawSetInt(1, lua.LString("foo"))
 			table.RawSetInt(2, lua.LString("bar"))
 
-			nv, err := luaconv.Decode(table, namesType)
+			nv, err := luaconv.Decode(L, table, namesType)
 			if err != nil {
 				Fail(err.Error())
 			}
@@ -233,12 +387,529 @@ var _ = Describe("Decode", func() {
 			table.RawSetInt(1, lua.LString("foo"))
 			table.RawSetInt(2, lua.LString("bar"))
 
-			nv, err := luaconv.Decode(table, namesType)
+			nv, err := luaconv.Decode(L, table, namesType)
 			if err != nil {
 				Fail(err.Error())
 			}
 
 			Expect(nv.Interface()).To(Equal(Names{"foo", "bar"}))
 		})
+
+		It("should apply the ArrayLength policy when the lengths differ", func() {
+			L := lua.NewState()
+			conv := luaconv.NewConverter()
+
+			type Names [2]string
+			namesType := reflect.TypeOf(Names{})
+
+			long := L.NewTable()
+			long.RawSetInt(1, lua.LString("foo"))
+			long.RawSetInt(2, lua.LString("bar"))
+			long.RawSetInt(3, lua.LString("baz"))
+
+			short := L.NewTable()
+			short.RawSetInt(1, lua.LString("foo"))
+
+			_, err := conv.Decode(L, long, namesType)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("length 3 is greater than 2"))
+
+			nv, err := conv.Decode(L, short, namesType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(Names{"foo", ""}))
+
+			conv.DecodeOptions.ArrayLength = luaconv.ArrayLengthExact
+
+			_, err = conv.Decode(L, short, namesType)
+			Expect(err).To(HaveOccurred())
+
+			conv.DecodeOptions.ArrayLength = luaconv.ArrayLengthTruncate
+
+			nv, err = conv.Decode(L, long, namesType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(Names{"foo", "bar"}))
+
+			nv, err = conv.Decode(L, lua.LString("abcde"), reflect.TypeOf([4]byte{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([4]byte{'a', 'b', 'c', 'd'}))
+		})
+	})
+
+	Context("when given an LFunction and a func destType", func() {
+		It("should return a Go function that calls the Lua function", func() {
+			L := lua.NewState()
+
+			err := L.DoString(`function add(a, b) return a + b end`)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			nv, err := luaconv.Decode(L, L.GetGlobal("add"), reflect.TypeOf(func(int, int) int { return 0 }))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			add := nv.Interface().(func(int, int) int)
+			Expect(add(2, 3)).To(Equal(5))
+		})
+
+		It("should return Lua errors through a trailing error return value", func() {
+			L := lua.NewState()
+
+			err := L.DoString(`function check(s) if s == 'bad' then error('bad input') end return #s end`)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			nv, err := luaconv.Decode(L, L.GetGlobal("check"), reflect.TypeOf(func(string) (int, error) { return 0, nil }))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			check := nv.Interface().(func(string) (int, error))
+
+			n, err := check("good")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(4))
+
+			_, err = check("bad")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("bad input"))
+		})
+	})
+
+	Context("when given a pointer destType", func() {
+		type Item struct {
+			Name string `lua:"name"`
+		}
+
+		It("should allocate a new value and decode into it", func() {
+			L := lua.NewState()
+
+			table := L.NewTable()
+			table.RawSetString("name", lua.LString("foo"))
+
+			nv, err := luaconv.Decode(L, table, reflect.TypeOf(&Item{}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(nv.Interface()).To(Equal(&Item{Name: "foo"}))
+		})
+
+		It("should decode slices and maps of pointers", func() {
+			L := lua.NewState()
+
+			item := L.NewTable()
+			item.RawSetString("name", lua.LString("foo"))
+
+			list := L.NewTable()
+			list.Append(item)
+
+			nv, err := luaconv.Decode(L, list, reflect.TypeOf([]*Item{}))
+			if err != nil {
+				Fail(err.Error())
+			}
+			Expect(nv.Interface()).To(Equal([]*Item{{Name: "foo"}}))
+
+			byName := L.NewTable()
+			byName.RawSetString("foo", item)
+
+			nv, err = luaconv.Decode(L, byName, reflect.TypeOf(map[string]*Item{}))
+			if err != nil {
+				Fail(err.Error())
+			}
+			Expect(nv.Interface()).To(Equal(map[string]*Item{"foo": {Name: "foo"}}))
+		})
+
+		It("should decode nil to a nil pointer", func() {
+			nv, err := luaconv.Decode(nil, lua.LNil, reflect.TypeOf(&Item{}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(nv.IsNil()).To(BeTrue())
+		})
+	})
+
+	Context("when given a table that is referenced more than once", func() {
+		type Node struct {
+			Name string `lua:"name"`
+			Next *Node  `lua:"next"`
+		}
+
+		It("should decode it as the same pointer each time, even when cyclic", func() {
+			L := lua.NewState()
+
+			err := L.DoString(`
+                a = { name = 'a' }
+                b = { name = 'b', next = a }
+                a.next = b
+                nodes = { a, b, a }
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			nv, err := luaconv.Decode(L, L.GetGlobal("nodes"), reflect.TypeOf([]*Node{}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			nodes := nv.Interface().([]*Node)
+			Expect(nodes[0]).To(BeIdenticalTo(nodes[2]))
+			Expect(nodes[0].Next).To(BeIdenticalTo(nodes[1]))
+			Expect(nodes[1].Next).To(BeIdenticalTo(nodes[0]))
+			Expect(nodes[1].Name).To(Equal("b"))
+		})
+
+		It("should decode it as the same map each time", func() {
+			L := lua.NewState()
+
+			err := L.DoString(`
+                t = {}
+                t.self = t
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			nv, err := luaconv.Decode(L, L.GetGlobal("t"), reflect.TypeOf(map[string]interface{}{}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			m := nv.Interface().(map[string]interface{})
+			Expect(reflect.ValueOf(m["self"]).Pointer()).To(Equal(reflect.ValueOf(m).Pointer()))
+		})
+	})
+
+	Context("when given a table with holes or keys outside of its array part", func() {
+		var (
+			L    *lua.LState
+			conv *luaconv.Converter
+		)
+
+		interfaceType := reflect.TypeOf((*interface{})(nil)).Elem()
+
+		BeforeEach(func() {
+			L = lua.NewState()
+			conv = luaconv.NewConverter()
+
+			err := L.DoString(`
+                holey = { 'a', nil, nil, 'd' }
+                mixed = { 'a', 'b', name = 'x' }
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+
+		It("should fill holes and discard other keys by default", func() {
+			nv, err := conv.Decode(L, L.GetGlobal("holey"), reflect.TypeOf([]string{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([]string{"a", "", "", "d"}))
+
+			nv, err = conv.Decode(L, L.GetGlobal("mixed"), interfaceType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([]interface{}{"a", "b"}))
+		})
+
+		It("should stop at the first hole in TableShapeStrict mode", func() {
+			conv.DecodeOptions.TableShape = luaconv.TableShapeStrict
+
+			nv, err := conv.Decode(L, L.GetGlobal("holey"), reflect.TypeOf([]string{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([]string{"a"}))
+
+			nv, err = conv.Decode(L, L.GetGlobal("holey"), interfaceType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(map[interface{}]interface{}{float64(1): "a", float64(4): "d"}))
+		})
+
+		It("should decode mixed tables into interface{} as maps in TableShapeMixed mode", func() {
+			conv.DecodeOptions.TableShape = luaconv.TableShapeMixed
+
+			nv, err := conv.Decode(L, L.GetGlobal("mixed"), interfaceType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(map[interface{}]interface{}{
+				float64(1): "a",
+				float64(2): "b",
+				"name":     "x",
+			}))
+
+			nv, err = conv.Decode(L, L.GetGlobal("holey"), interfaceType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([]interface{}{"a", nil, nil, "d"}))
+		})
+
+		It("should fail instead of discarding keys in StrictArrays mode", func() {
+			conv.DecodeOptions.StrictArrays = true
+
+			_, err := conv.Decode(L, L.GetGlobal("mixed"), reflect.TypeOf([]string{}))
+			Expect(err).To(HaveOccurred())
+
+			_, err = conv.Decode(L, L.GetGlobal("holey"), reflect.TypeOf([]string{}))
+			Expect(err).NotTo(HaveOccurred())
+
+			conv.DecodeOptions.TableShape = luaconv.TableShapeStrict
+
+			_, err = conv.Decode(L, L.GetGlobal("holey"), reflect.TypeOf([]string{}))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when given LNil", func() {
+		It("should return the zero value of destType", func() {
+			tests := []interface{}{0, "", false, 1.5, []string{}, map[string]int{}, struct{ A int }{}, [2]int{}}
+
+			for _, test := range tests {
+				destType := reflect.TypeOf(test)
+
+				nv, err := luaconv.Decode(nil, lua.LNil, destType)
+				if err != nil {
+					Fail(err.Error())
+				}
+
+				Expect(nv.Interface()).To(Equal(reflect.Zero(destType).Interface()))
+			}
+		})
+
+		It("should decode holes in a Lua array to zero values", func() {
+			L := lua.NewState()
+
+			table := L.NewTable()
+			table.RawSetInt(1, lua.LString("foo"))
+			table.RawSetInt(3, lua.LString("bar"))
+
+			nv, err := luaconv.Decode(L, table, reflect.TypeOf([3]string{}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(nv.Interface()).To(Equal([3]string{"foo", "", "bar"}))
+		})
+
+		It("should reject nil for non-nillable types in StrictNil mode", func() {
+			conv := luaconv.NewConverter()
+			conv.DecodeOptions.StrictNil = true
+
+			_, err := conv.Decode(nil, lua.LNil, reflect.TypeOf(0))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cannot convert nil to int"))
+
+			nv, err := conv.Decode(nil, lua.LNil, reflect.TypeOf([]int{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.IsNil()).To(BeTrue())
+		})
+	})
+
+	Context("when given an LNumber in StrictNumbers mode", func() {
+		var conv *luaconv.Converter
+
+		BeforeEach(func() {
+			conv = luaconv.NewConverter()
+			conv.DecodeOptions.StrictNumbers = true
+		})
+
+		It("should reject numbers with a fractional part for integer types", func() {
+			_, err := conv.Decode(nil, lua.LNumber(3.7), reflect.TypeOf(0))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("3.7 is not an integer"))
+		})
+
+		It("should reject numbers that are out of range for the destination type", func() {
+			_, err := conv.Decode(nil, lua.LNumber(300), reflect.TypeOf(uint8(0)))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("300 is out of range"))
+
+			_, err = conv.Decode(nil, lua.LNumber(-1), reflect.TypeOf(uint64(0)))
+			Expect(err).To(HaveOccurred())
+
+			_, err = conv.Decode(nil, lua.LNumber(128), reflect.TypeOf(int8(0)))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should accept integral, in-range numbers", func() {
+			nv, err := conv.Decode(nil, lua.LNumber(-128), reflect.TypeOf(int8(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(int8(-128)))
+
+			nv, err = conv.Decode(nil, lua.LNumber(255), reflect.TypeOf(uint8(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(uint8(255)))
+
+			nv, err = conv.Decode(nil, lua.LNumber(3.7), reflect.TypeOf(float32(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(float32(3.7)))
+		})
+	})
+
+	Context("in WeaklyTyped mode", func() {
+		var conv *luaconv.Converter
+
+		BeforeEach(func() {
+			conv = luaconv.NewConverter()
+			conv.DecodeOptions.WeaklyTyped = true
+		})
+
+		It("should convert between strings and numbers", func() {
+			nv, err := conv.Decode(nil, lua.LString(" 8080 "), reflect.TypeOf(0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(8080))
+
+			nv, err = conv.Decode(nil, lua.LString("0.5"), reflect.TypeOf(float32(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(float32(0.5)))
+
+			nv, err = conv.Decode(nil, lua.LNumber(8080), reflect.TypeOf(""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal("8080"))
+
+			_, err = conv.Decode(nil, lua.LString("eighty"), reflect.TypeOf(0))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should parse strings with Lua's number syntax", func() {
+			nv, err := conv.Decode(nil, lua.LString("0x10"), reflect.TypeOf(0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(16))
+
+			nv, err = conv.Decode(nil, lua.LString("-0XfF"), reflect.TypeOf(float64(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(float64(-255)))
+
+			nv, err = conv.Decode(nil, lua.LString("1e3"), reflect.TypeOf(0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(1000))
+
+			for _, str := range []string{"nan", "inf", "-Infinity", "0x", "1e400"} {
+				_, err = conv.Decode(nil, lua.LString(str), reflect.TypeOf(0))
+				Expect(err).To(HaveOccurred(), str)
+			}
+
+			_, err = conv.Decode(nil, lua.LString("nan"), reflect.TypeOf(float64(0)))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should still convert strings to numbers when LargeIntsAsStrings is also set", func() {
+			conv.LargeIntsAsStrings = true
+
+			nv, err := conv.Decode(nil, lua.LString(" 8080 "), reflect.TypeOf(0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(8080))
+
+			nv, err = conv.Decode(nil, lua.LString("12.0"), reflect.TypeOf(int64(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(int64(12)))
+
+			nv, err = conv.Decode(nil, lua.LString("1152921504606846977"), reflect.TypeOf(int64(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(int64(1<<60 + 1)))
+		})
+
+		It("should convert between numbers and bools", func() {
+			nv, err := conv.Decode(nil, lua.LNumber(0), reflect.TypeOf(false))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(false))
+
+			nv, err = conv.Decode(nil, lua.LNumber(2), reflect.TypeOf(false))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(true))
+
+			nv, err = conv.Decode(nil, lua.LTrue, reflect.TypeOf(uint8(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(uint8(1)))
+		})
+
+		It("should convert a single scalar into a one-element slice", func() {
+			nv, err := conv.Decode(nil, lua.LString("foo"), reflect.TypeOf([]string{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([]string{"foo"}))
+
+			nv, err = conv.Decode(nil, lua.LString("80"), reflect.TypeOf([]int{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([]int{80}))
+		})
+
+		It("should not apply when WeaklyTyped is not set", func() {
+			_, err := luaconv.Decode(nil, lua.LString("8080"), reflect.TypeOf(0))
+			Expect(err).To(HaveOccurred())
+
+			_, err = luaconv.Decode(nil, lua.LNumber(1), reflect.TypeOf(false))
+			Expect(err).To(HaveOccurred())
+
+			_, err = luaconv.Decode(nil, lua.LString("foo"), reflect.TypeOf([]string{}))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when given a complex destType", func() {
+		It("should accept numbers and tables with missing parts", func() {
+			L := lua.NewState()
+
+			nv, err := luaconv.Decode(L, lua.LNumber(3), reflect.TypeOf(complex128(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(complex128(3)))
+
+			table := L.NewTable()
+			table.RawSetString("im", lua.LNumber(4))
+
+			nv, err = luaconv.Decode(L, table, reflect.TypeOf(complex128(0)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(complex128(4i)))
+		})
+
+		It("should fail if a part is not a number", func() {
+			L := lua.NewState()
+
+			table := L.NewTable()
+			table.RawSetString("re", lua.LString("one"))
+
+			_, err := luaconv.Decode(L, table, reflect.TypeOf(complex128(0)))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail if the table has other keys, or neither part", func() {
+			L := lua.NewState()
+
+			for _, script := range []string{`return {"x"}`, `return {1, 2}`, `return {real = 1}`, `return {re = 1, x = 2}`, `return {}`} {
+				if err := L.DoString(script); err != nil {
+					Fail(err.Error())
+				}
+				table := L.Get(-1)
+				L.Pop(1)
+
+				_, err := luaconv.Decode(L, table, reflect.TypeOf(complex128(0)))
+				Expect(err).To(HaveOccurred(), script)
+			}
+
+			err := L.DoString(`return {re = 1, x = 2}`)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			_, err = luaconv.Decode(L, L.Get(-1), reflect.TypeOf(complex128(0)))
+			Expect(err).To(MatchError(ContainSubstring("unexpected key x")))
+		})
+	})
+
+	Context("when given an LString and a byte slice or array destType", func() {
+		It("should copy the string's bytes", func() {
+			type Payload []byte
+
+			nv, err := luaconv.Decode(nil, lua.LString("\x00\xffab"), reflect.TypeOf(Payload{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(Payload{0, 255, 'a', 'b'}))
+
+			nv, err = luaconv.Decode(nil, lua.LString("ab"), reflect.TypeOf([4]byte{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([4]byte{'a', 'b', 0, 0}))
+		})
+
+		It("should fail if the string is longer than the array", func() {
+			_, err := luaconv.Decode(nil, lua.LString("abcde"), reflect.TypeOf([4]byte{}))
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
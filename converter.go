@@ -0,0 +1,234 @@
+package luaconv
+
+import (
+	"reflect"
+	"runtime/debug"
+	"sync"
+
+	"github.com/yuin/gopher-lua"
+)
+
+type (
+	// EncodeFunc converts a Go value into a Lua value.
+	EncodeFunc func(L *lua.LState, goval reflect.Value) (lua.LValue, error)
+
+	// DecodeFunc converts a Lua value into a Go value of type `destType`.  It is never passed nil,
+	// which always decodes to the zero value.
+	DecodeFunc func(L *lua.LState, lv lua.LValue, destType reflect.Type) (reflect.Value, error)
+
+	// Converter converts values back and forth between Go and Lua.  Custom conversions for specific
+	// Go types can be added with Register, and are consulted before the default handling for the
+	// type's kind.
+	//
+	// The package-level functions (Encode, Decode, Wrap, Unwrap, NewStructCoder) use
+	// DefaultConverter.  A Converter's exported fields should not be modified while it is in use.
+	Converter struct {
+		// ErrorMode determines how a non-nil trailing `error` returned by a wrapped Go function is
+		// surfaced to Lua.
+		ErrorMode ErrorMode
+
+		// PanicStackTraces makes the Lua errors raised for Go panics in wrapped functions and
+		// metamethods include the Go stack trace of the panic, which is useful while debugging but
+		// may reveal more than scripts should see.
+		PanicStackTraces bool
+
+		// SortMapKeys makes `pairs` visit the entries of maps wrapped by Wrap in sorted key order,
+		// rather than Go's randomized map order.
+		SortMapKeys bool
+
+		// StrictIndexing makes reading a wrapped slice or array at an index that is out of range,
+		// or not an integer, raise a Lua error.  Otherwise it reads as nil, as with tables.
+		// Writing to such an index always raises an error, except that assigning to index #s+1
+		// appends to a slice.
+		StrictIndexing bool
+
+		// NegativeIndexing makes negative indices into wrapped slices and arrays count back from
+		// the end, so that s[-1] is the last element.  The same applies to the positions taken by
+		// the insert, remove and slice methods, so that s:remove(-2) removes the next to last.
+		NegativeIndexing bool
+
+		// LargeIntsAsStrings makes Encode and Wrap convert integers that a Lua number (a float64)
+		// cannot represent exactly, i.e. those beyond ±2^53, into decimal strings, and makes Decode
+		// and Unwrap accept decimal strings for integer types.  This allows e.g. int64 IDs to round
+		// trip through Lua without losing precision.
+		LargeIntsAsStrings bool
+
+		// DecodeOptions configures Decode, Unwrap and StructCoder.TableToStruct.
+		DecodeOptions DecodeOptions
+
+		mutex      sync.RWMutex
+		codecs     map[reflect.Type]codec
+		methodsets *methodsetCache
+	}
+
+	// DecodeOptions configures how a Converter's Decode, Unwrap and StructCoder.TableToStruct convert
+	// Lua values into Go values.
+	DecodeOptions struct {
+		// StrictNil makes it an error to decode nil into a Go type that cannot be nil (bools, numbers,
+		// strings, structs and arrays).  Otherwise, nil decodes to the type's zero value.
+		StrictNil bool
+
+		// CollectErrors makes decoding continue past values that cannot be decoded, leaving them
+		// unset (or zeroed, for slice and array elements).  The partially decoded value is returned
+		// along with a ConversionErrors containing every failure.
+		CollectErrors bool
+
+		// StrictNumbers makes it an error to decode a number with a fractional part into an integer
+		// type, or a number that is out of range for the destination type (such as 300 into a
+		// uint8).  Otherwise, numbers are converted as by a Go conversion and may be truncated.
+		StrictNumbers bool
+
+		// WeaklyTyped allows conversions that Lua itself would perform implicitly, or that are
+		// convenient in hand-written configuration: strings to numbers and vice versa, numbers to
+		// bools (zero is false) and vice versa (false is zero), and a single scalar value to a
+		// one-element slice.
+		WeaklyTyped bool
+
+		// MaxDepth limits how deeply tables may be nested, counting the top-level table as depth 1.
+		// MaxElements limits the total number of table entries decoded, and MaxStringLen limits the
+		// length of each string.  They guard against untrusted scripts that produce huge or deeply
+		// nested values.  A value that exceeds one of them fails with a ConversionError whose Err is
+		// a *LimitError, even in CollectErrors mode.  Zero means no limit.
+		MaxDepth     int
+		MaxElements  int
+		MaxStringLen int
+
+		// TableShape determines which keys of a table make up its array part, and whether a table
+		// decoded into an interface{} becomes a slice or a map.
+		TableShape TableShape
+
+		// StrictArrays makes it an error to decode a table into a slice or array if the table has
+		// keys outside of its array part (as determined by TableShape), which would otherwise be
+		// silently discarded.
+		StrictArrays bool
+
+		// ArrayLength determines what happens when the number of elements in a table or string
+		// doesn't match the length of the Go array it is being decoded into.
+		ArrayLength ArrayLength
+	}
+
+	codec struct {
+		encode EncodeFunc
+		decode DecodeFunc
+	}
+)
+
+// TableShape is a policy for telling arrays apart from maps when decoding Lua tables.
+type TableShape int
+
+const (
+	// TableShapeSequence treats the keys 1 through table.MaxN() as a table's array part, decoding
+	// any holes as the element type's zero value (nil, for interface{}).  Tables with an array
+	// part decode into interface{} as a []interface{}, discarding any other keys, and other tables
+	// as a map[string]interface{}.
+	TableShapeSequence TableShape = iota
+
+	// TableShapeStrict treats only the keys 1 through n as a table's array part, where n+1 is the
+	// first missing key (as with ipairs).  Only tables whose keys are all part of the array part
+	// decode into interface{} as a []interface{}.  Other tables decode as a map[string]interface{}
+	// if all of their keys are strings, and a map[interface{}]interface{} otherwise.
+	TableShapeStrict
+
+	// TableShapeMixed is like TableShapeSequence, except that tables with keys outside of their
+	// array part decode into interface{} as maps, as with TableShapeStrict.
+	TableShapeMixed
+)
+
+// ArrayLength is a policy for decoding tables and strings into Go arrays of a different length.
+type ArrayLength int
+
+const (
+	// ArrayLengthAtMost makes it an error for a table or string to have more elements than the
+	// array.  If it has fewer, the remaining array elements are zeroed.
+	ArrayLengthAtMost ArrayLength = iota
+
+	// ArrayLengthExact makes it an error for a table or string to have more or fewer elements
+	// than the array.
+	ArrayLengthExact
+
+	// ArrayLengthTruncate discards elements beyond the length of the array.  If there are fewer,
+	// the remaining array elements are zeroed.
+	ArrayLengthTruncate
+)
+
+// DefaultConverter is the Converter used by the package-level functions.
+var DefaultConverter = NewConverter()
+
+func NewConverter() *Converter {
+	c := &Converter{
+		ErrorMode: ErrorModeReturn,
+		mutex:     sync.RWMutex{},
+		codecs:    map[reflect.Type]codec{},
+	}
+	c.methodsets = newMethodsetCache(c)
+	return c
+}
+
+// Register sets the functions used to convert values of type `vtype`.  `encode` is used by Encode
+// and Wrap, and `decode` is used by Decode and Unwrap.  Either may be nil, in which case the default
+// handling is used for that direction.
+func (c *Converter) Register(vtype reflect.Type, encode EncodeFunc, decode DecodeFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.codecs[vtype] = codec{encode: encode, decode: decode}
+}
+
+// recoverPanic converts a Go panic within the entry point `op` into a *PanicError that is returned
+// through `err`.  It must be deferred directly by the entry point.
+func (c *Converter) recoverPanic(op string, err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{Op: op, Value: r, Stack: debug.Stack()}
+	}
+}
+
+// protect wraps a Go function that is called from Lua so that a Go panic inside of it is raised as a
+// Lua error instead of unwinding through the Lua VM and crashing the host.  Lua errors, which
+// gopher-lua raises as panics of their own, are passed through unchanged.
+func (c *Converter) protect(fn func(*lua.LState) int) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			} else if _, is := r.(*lua.ApiError); is {
+				panic(r)
+			}
+
+			perr := &PanicError{Value: r, Stack: debug.Stack()}
+			if c.PanicStackTraces {
+				L.RaiseError("%v\n%s", perr, perr.Stack)
+			} else {
+				L.RaiseError("%v", perr)
+			}
+		}()
+
+		return fn(L)
+	}
+}
+
+func (c *Converter) encoderFor(vtype reflect.Type) EncodeFunc {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.codecs[vtype].encode
+}
+
+// hasCustomEncoding returns true if values of type `vtype` are encoded by a registered EncodeFunc or
+// a marshaler method rather than by the default handling for their kind.
+func (c *Converter) hasCustomEncoding(vtype reflect.Type) bool {
+	if c.encoderFor(vtype) != nil {
+		return true
+	}
+
+	ptrType := reflect.PtrTo(vtype)
+	return vtype.Implements(luaMarshalerType) || ptrType.Implements(luaMarshalerType) ||
+		vtype.Implements(textMarshalerType) || ptrType.Implements(textMarshalerType)
+}
+
+func (c *Converter) decoderFor(vtype reflect.Type) DecodeFunc {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.codecs[vtype].decode
+}
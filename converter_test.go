@@ -0,0 +1,195 @@
+package luaconv_test
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/yuin/gopher-lua"
+
+	"github.com/brynbellomy/go-luaconv"
+)
+
+var _ = Describe("Converter", func() {
+	var (
+		L    *lua.LState
+		conv *luaconv.Converter
+	)
+
+	timeType := reflect.TypeOf(time.Time{})
+	ipType := reflect.TypeOf(net.IP{})
+
+	BeforeEach(func() {
+		L = lua.NewState()
+		conv = luaconv.NewConverter()
+
+		conv.Register(timeType,
+			func(L *lua.LState, goval reflect.Value) (lua.LValue, error) {
+				return lua.LString(goval.Interface().(time.Time).Format(time.RFC3339)), nil
+			},
+			nil,
+		)
+
+		conv.Register(ipType,
+			nil,
+			func(L *lua.LState, lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
+				return reflect.ValueOf(net.ParseIP(lua.LVAsString(lv))), nil
+			},
+		)
+	})
+
+	Context("when a type has a registered encoder", func() {
+		It("should be used by Encode, including for struct fields", func() {
+			type Event struct {
+				At time.Time `lua:"at"`
+			}
+
+			at := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+			lv, err := conv.Encode(L, reflect.ValueOf(Event{At: at}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(lv.(*lua.LTable).RawGetString("at")).To(Equal(lua.LString("2016-01-02T03:04:05Z")))
+		})
+
+		It("should be used by Wrap", func() {
+			at := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+			lv, err := conv.Wrap(L, reflect.ValueOf(at))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(lv).To(Equal(lua.LString("2016-01-02T03:04:05Z")))
+		})
+
+		It("should not affect other Converters", func() {
+			at := time.Date(2016, 1, 2, 3, 4, 5, 6, time.UTC)
+
+			lv, err := luaconv.Encode(L, reflect.ValueOf(at))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(lv).To(Equal(lua.LString("2016-01-02T03:04:05.000000006Z")))
+		})
+	})
+
+	Context("when a type has a registered decoder", func() {
+		It("should be used by Decode, including for map values", func() {
+			table := L.NewTable()
+			table.RawSetString("gateway", lua.LString("10.0.0.1"))
+
+			nv, err := conv.Decode(L, table, reflect.TypeOf(map[string]net.IP{}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(nv.Interface()).To(Equal(map[string]net.IP{"gateway": net.ParseIP("10.0.0.1")}))
+		})
+
+		It("should be used by Unwrap when calling wrapped functions", func() {
+			var got net.IP
+			luafn, err := conv.Wrap(L, reflect.ValueOf(func(ip net.IP) {
+				got = ip
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("thefunc", luafn)
+
+			err = L.DoString(`thefunc('192.168.1.1')`)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(got).To(Equal(net.ParseIP("192.168.1.1")))
+		})
+
+		It("should not be passed nil, which decodes to the zero value", func() {
+			conv.Register(timeType, nil,
+				func(L *lua.LState, lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
+					t, err := time.Parse(time.RFC3339, lua.LVAsString(lv))
+					return reflect.ValueOf(t), err
+				},
+			)
+
+			nv, err := conv.Decode(L, lua.LNil, timeType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal(time.Time{}))
+
+			table := L.NewTable()
+			table.RawSetInt(2, lua.LString("2016-01-02T03:04:05Z"))
+
+			nv, err = conv.Decode(L, table, reflect.TypeOf([2]time.Time{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nv.Interface()).To(Equal([2]time.Time{{}, time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)}))
+		})
+	})
+
+	Context("when LargeIntsAsStrings is set", func() {
+		BeforeEach(func() {
+			conv.LargeIntsAsStrings = true
+		})
+
+		It("should encode integers beyond 2^53 as decimal strings that decode back exactly", func() {
+			ids := []interface{}{int64(1<<60 + 1), int64(-1<<60 - 1), uint64(math.MaxUint64)}
+
+			for _, id := range ids {
+				lv, err := conv.Encode(L, reflect.ValueOf(id))
+				if err != nil {
+					Fail(err.Error())
+				}
+				Expect(lv).To(BeAssignableToTypeOf(lua.LString("")))
+
+				nv, err := conv.Decode(L, lv, reflect.TypeOf(id))
+				if err != nil {
+					Fail(err.Error())
+				}
+				Expect(nv.Interface()).To(Equal(id))
+			}
+		})
+
+		It("should still encode smaller integers as numbers", func() {
+			lv, err := conv.Encode(L, reflect.ValueOf(int64(1<<53)))
+			if err != nil {
+				Fail(err.Error())
+			}
+			Expect(lv).To(Equal(lua.LNumber(1 << 53)))
+		})
+
+		It("should apply to Wrap and Unwrap", func() {
+			var got int64
+			luafn, err := conv.Wrap(L, reflect.ValueOf(func(id int64) int64 {
+				got = id
+				return id + 1
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("thefunc", luafn)
+
+			err = L.DoString(`assert(thefunc('1152921504606846977') == '1152921504606846978')`)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(got).To(Equal(int64(1<<60 + 1)))
+		})
+
+		It("should reject strings that are not valid integers of the destination type", func() {
+			_, err := conv.Decode(L, lua.LString("300"), reflect.TypeOf(uint8(0)))
+			Expect(err).To(HaveOccurred())
+
+			_, err = conv.Decode(L, lua.LString("12abc"), reflect.TypeOf(int64(0)))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
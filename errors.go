@@ -0,0 +1,143 @@
+package luaconv
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// ConversionError describes a Lua value that could not be converted into a Go value.
+type ConversionError struct {
+	// Op is the name of the function that failed, e.g. "Decode" or "Unwrap".
+	Op string
+
+	// Path locates the offending value within the Lua value that was being converted, e.g.
+	// `servers[3].port`.  It is empty if the offending value is the top-level value.
+	Path string
+
+	// LuaType describes the type of the offending Lua value, e.g. "string" or "userdata(*foo.Bar)".
+	LuaType string
+
+	// GoType is the Go type that the Lua value was being converted into.
+	GoType reflect.Type
+
+	// Err is the underlying cause, if any, such as an error returned by a LuaUnmarshaler.
+	Err error
+}
+
+func (e *ConversionError) Error() string {
+	msg := "luaconv." + e.Op + ": "
+	if e.Path != "" {
+		msg += e.Path + ": "
+	}
+
+	msg += fmt.Sprintf("cannot convert %v to %v", e.LuaType, e.GoType)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying cause of the error, if any.
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// ConversionErrors is returned by Decode, Unwrap and StructCoder.TableToStruct in CollectErrors mode,
+// and contains an error for every value that could not be decoded.
+type ConversionErrors []*ConversionError
+
+func (errs ConversionErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i := range errs {
+		msgs[i] = errs[i].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LimitError is the cause of a ConversionError for a Lua value that exceeds one of the limits in
+// DecodeOptions.
+type LimitError struct {
+	// Limit is the name of the DecodeOptions field that was exceeded, e.g. "MaxDepth".
+	Limit string
+
+	// Max is the value of that field.
+	Max int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("exceeds %v of %v", e.Limit, e.Max)
+}
+
+// PanicError describes a Go panic that occurred during a conversion, or during a call from Lua into a
+// wrapped Go function or metamethod.  It is returned by Encode, Decode, Wrap, Unwrap and the
+// StructCoder methods, and its message is raised as a Lua error by wrapped functions.
+type PanicError struct {
+	// Op is the name of the function that panicked, e.g. "Encode".  It is empty for panics in
+	// functions called from Lua.
+	Op string
+
+	// Value is the value that was passed to panic.
+	Value interface{}
+
+	// Stack is the Go stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	msg := fmt.Sprintf("panic: %v", e.Value)
+	if e.Op != "" {
+		msg = "luaconv." + e.Op + ": " + msg
+	}
+	return msg
+}
+
+// luaTypeName describes the type of a Lua value for error messages.  Userdata values include the
+// type of the Go value they wrap.
+func luaTypeName(lv lua.LValue) string {
+	if ud, is := lv.(*lua.LUserData); is {
+		if rval, is := ud.Value.(reflect.Value); is {
+			return fmt.Sprintf("userdata(%v)", rval.Type())
+		}
+	}
+	return lv.Type().String()
+}
+
+// formatPath joins path segments created by pathSegment into a string like `servers[3].port`.
+func formatPath(segments []string) string {
+	return strings.TrimPrefix(strings.Join(segments, ""), ".")
+}
+
+// pathSegment formats a Lua table key as a path segment: `.name` for keys that are valid Lua
+// identifiers, and `[3]` or `["some key"]` for anything else.
+func pathSegment(key lua.LValue) string {
+	switch key := key.(type) {
+	case lua.LString:
+		if isLuaIdentifier(string(key)) {
+			return "." + string(key)
+		}
+		return fmt.Sprintf("[%q]", string(key))
+	case lua.LNumber:
+		return "[" + key.String() + "]"
+	default:
+		return "[" + luaTypeName(key) + "]"
+	}
+}
+
+func isLuaIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
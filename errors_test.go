@@ -0,0 +1,232 @@
+package luaconv_test
+
+import (
+	"reflect"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/yuin/gopher-lua"
+
+	"github.com/brynbellomy/go-luaconv"
+)
+
+var _ = Describe("ConversionError", func() {
+	type Server struct {
+		Host string `lua:"host"`
+		Port int    `lua:"port"`
+	}
+
+	type Config struct {
+		Servers []Server          `lua:"servers"`
+		Labels  map[string]string `lua:"labels"`
+	}
+
+	var L *lua.LState
+
+	BeforeEach(func() {
+		L = lua.NewState()
+	})
+
+	It("should report the path to the offending value within nested tables", func() {
+		err := L.DoString(`
+            config = {
+                servers = {
+                    { host = 'a', port = 80 },
+                    { host = 'b', port = 'eighty' },
+                },
+            }
+        `)
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		_, err = luaconv.Decode(L, L.GetGlobal("config"), reflect.TypeOf(Config{}))
+		Expect(err).To(HaveOccurred())
+
+		convErr, is := err.(*luaconv.ConversionError)
+		Expect(is).To(BeTrue())
+		Expect(convErr.Op).To(Equal("Decode"))
+		Expect(convErr.Path).To(Equal("servers[2].port"))
+		Expect(convErr.LuaType).To(Equal("string"))
+		Expect(convErr.GoType).To(Equal(reflect.TypeOf(0)))
+		Expect(err.Error()).To(Equal("luaconv.Decode: servers[2].port: cannot convert string to int"))
+	})
+
+	It("should quote map keys that are not identifiers", func() {
+		err := L.DoString(`config = { labels = { ['app name'] = 123 } }`)
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		_, err = luaconv.Decode(L, L.GetGlobal("config"), reflect.TypeOf(Config{}))
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*luaconv.ConversionError).Path).To(Equal(`labels["app name"]`))
+	})
+
+	It("should have an empty path for top-level values", func() {
+		_, err := luaconv.Decode(L, lua.LBool(true), reflect.TypeOf(""))
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*luaconv.ConversionError).Path).To(Equal(""))
+		Expect(err.Error()).To(Equal("luaconv.Decode: cannot convert boolean to string"))
+	})
+
+	It("should be returned by StructCoder.TableToStruct", func() {
+		table := L.NewTable()
+		table.RawSetString("port", lua.LBool(true))
+
+		_, err := luaconv.NewStructCoder(reflect.TypeOf(Server{})).TableToStruct(L, table)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*luaconv.ConversionError).Op).To(Equal("StructCoder.TableToStruct"))
+		Expect(err.(*luaconv.ConversionError).Path).To(Equal("port"))
+	})
+})
+
+var _ = Describe("ConversionErrors", func() {
+	type Server struct {
+		Host string `lua:"host"`
+		Port int    `lua:"port"`
+	}
+
+	It("should contain every failure in CollectErrors mode, along with the partially decoded value", func() {
+		L := lua.NewState()
+
+		err := L.DoString(`
+            servers = {
+                { host = 'a', port = 'eighty' },
+                { host = 'b', port = 80 },
+                { host = false, port = 81 },
+            }
+        `)
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		conv := luaconv.NewConverter()
+		conv.DecodeOptions.CollectErrors = true
+
+		nv, err := conv.Decode(L, L.GetGlobal("servers"), reflect.TypeOf([]Server{}))
+		Expect(err).To(HaveOccurred())
+
+		errs, is := err.(luaconv.ConversionErrors)
+		Expect(is).To(BeTrue())
+
+		paths := []string{}
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		Expect(paths).To(Equal([]string{"[1].port", "[3].host"}))
+
+		Expect(nv.Interface()).To(Equal([]Server{
+			{Host: "a"},
+			{Host: "b", Port: 80},
+			{Port: 81},
+		}))
+	})
+
+	It("should not be returned when nothing fails", func() {
+		conv := luaconv.NewConverter()
+		conv.DecodeOptions.CollectErrors = true
+
+		nv, err := conv.Decode(nil, lua.LNumber(3), reflect.TypeOf(0))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nv.Interface()).To(Equal(3))
+	})
+})
+
+var _ = Describe("LimitError", func() {
+	var (
+		L    *lua.LState
+		conv *luaconv.Converter
+	)
+
+	BeforeEach(func() {
+		L = lua.NewState()
+		conv = luaconv.NewConverter()
+	})
+
+	limitOf := func(err error) *luaconv.LimitError {
+		convErr, is := err.(*luaconv.ConversionError)
+		Expect(is).To(BeTrue())
+
+		limitErr, is := convErr.Err.(*luaconv.LimitError)
+		Expect(is).To(BeTrue())
+		return limitErr
+	}
+
+	It("should be returned for tables nested deeper than MaxDepth", func() {
+		err := L.DoString(`t = { a = { b = { c = {} } } }`)
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		conv.DecodeOptions.MaxDepth = 3
+
+		_, err = conv.Decode(L, L.GetGlobal("t"), reflect.TypeOf((*interface{})(nil)).Elem())
+		Expect(err).To(HaveOccurred())
+		Expect(limitOf(err)).To(Equal(&luaconv.LimitError{Limit: "MaxDepth", Max: 3}))
+		Expect(err.(*luaconv.ConversionError).Path).To(Equal("a.b.c"))
+
+		conv.DecodeOptions.MaxDepth = 4
+
+		_, err = conv.Decode(L, L.GetGlobal("t"), reflect.TypeOf((*interface{})(nil)).Elem())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should be returned when the total number of table entries exceeds MaxElements", func() {
+		err := L.DoString(`t = { {1, 2, 3}, {4, 5, 6} }`)
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		conv.DecodeOptions.MaxElements = 7
+
+		_, err = conv.Decode(L, L.GetGlobal("t"), reflect.TypeOf([][]int{}))
+		Expect(err).To(HaveOccurred())
+		Expect(limitOf(err).Limit).To(Equal("MaxElements"))
+
+		conv.DecodeOptions.MaxElements = 8
+
+		_, err = conv.Decode(L, L.GetGlobal("t"), reflect.TypeOf([][]int{}))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should be returned for strings longer than MaxStringLen, even in CollectErrors mode", func() {
+		conv.DecodeOptions.MaxStringLen = 4
+		conv.DecodeOptions.CollectErrors = true
+
+		table := L.NewTable()
+		table.RawSetInt(1, lua.LString("abcd"))
+		table.RawSetInt(2, lua.LString("abcde"))
+		table.RawSetInt(3, lua.LString("abc"))
+
+		_, err := conv.Decode(L, table, reflect.TypeOf([]string{}))
+		Expect(err).To(HaveOccurred())
+		Expect(limitOf(err).Limit).To(Equal("MaxStringLen"))
+		Expect(err.(*luaconv.ConversionError).Path).To(Equal("[2]"))
+	})
+})
+
+var _ = Describe("PanicError", func() {
+	It("should be returned when a conversion panics", func() {
+		L := lua.NewState()
+		conv := luaconv.NewConverter()
+
+		type Widget struct{}
+		conv.Register(reflect.TypeOf(Widget{}),
+			func(L *lua.LState, goval reflect.Value) (lua.LValue, error) {
+				panic("widgets are not supported")
+			},
+			nil,
+		)
+
+		_, err := conv.Encode(L, reflect.ValueOf([]Widget{{}}))
+		Expect(err).To(HaveOccurred())
+
+		panicErr, is := err.(*luaconv.PanicError)
+		Expect(is).To(BeTrue())
+		Expect(panicErr.Op).To(Equal("Encode"))
+		Expect(panicErr.Value).To(Equal("widgets are not supported"))
+		Expect(panicErr.Stack).NotTo(BeEmpty())
+		Expect(err.Error()).To(Equal("luaconv.Encode: panic: widgets are not supported"))
+	})
+})
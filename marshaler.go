@@ -0,0 +1,131 @@
+package luaconv
+
+import (
+	"encoding"
+	"reflect"
+
+	"github.com/yuin/gopher-lua"
+)
+
+type (
+	// LuaMarshaler is implemented by types that can convert themselves into a Lua value.  It is
+	// consulted by Encode and Wrap.
+	LuaMarshaler interface {
+		MarshalLua(L *lua.LState) (lua.LValue, error)
+	}
+
+	// LuaUnmarshaler is implemented by types that can populate themselves from a Lua value.  It is
+	// consulted by Decode and Unwrap when a pointer to the destination type implements it, except
+	// for nil, which always decodes to the zero value.
+	LuaUnmarshaler interface {
+		UnmarshalLua(lv lua.LValue) error
+	}
+)
+
+var (
+	luaMarshalerType    = reflect.TypeOf((*LuaMarshaler)(nil)).Elem()
+	luaUnmarshalerType  = reflect.TypeOf((*LuaUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// marshalLua calls goval's MarshalLua method.  The returned bool is false if goval does not implement
+// LuaMarshaler.
+func marshalLua(L *lua.LState, goval reflect.Value) (lua.LValue, bool, error) {
+	if goval.Kind() == reflect.Interface {
+		return nil, false, nil
+	}
+
+	if goval.Type().Implements(luaMarshalerType) {
+		if goval.Kind() == reflect.Ptr && goval.IsNil() {
+			return lua.LNil, true, nil
+		}
+
+		lv, err := goval.Interface().(LuaMarshaler).MarshalLua(L)
+		return lv, true, err
+
+	} else if goval.CanAddr() && reflect.PtrTo(goval.Type()).Implements(luaMarshalerType) {
+		lv, err := goval.Addr().Interface().(LuaMarshaler).MarshalLua(L)
+		return lv, true, err
+	}
+
+	return nil, false, nil
+}
+
+// unmarshalLua creates a new value of type destType and calls its UnmarshalLua method.  The returned
+// bool is false if neither destType nor a pointer to it implements LuaUnmarshaler.
+func unmarshalLua(lv lua.LValue, destType reflect.Type) (reflect.Value, bool, error) {
+	if destType.Kind() == reflect.Interface {
+		return reflect.Value{}, false, nil
+	}
+
+	if reflect.PtrTo(destType).Implements(luaUnmarshalerType) {
+		ptr := reflect.New(destType)
+		err := ptr.Interface().(LuaUnmarshaler).UnmarshalLua(lv)
+		return ptr.Elem(), true, err
+
+	} else if destType.Kind() == reflect.Ptr && destType.Implements(luaUnmarshalerType) {
+		ptr := reflect.New(destType.Elem())
+		err := ptr.Interface().(LuaUnmarshaler).UnmarshalLua(lv)
+		return ptr, true, err
+	}
+
+	return reflect.Value{}, false, nil
+}
+
+// marshalText encodes goval as an LString using its MarshalText method.  The returned bool is false
+// if goval does not implement encoding.TextMarshaler.
+func marshalText(goval reflect.Value) (lua.LValue, bool, error) {
+	if goval.Kind() == reflect.Interface {
+		return nil, false, nil
+	}
+
+	var marshaler encoding.TextMarshaler
+	if goval.Type().Implements(textMarshalerType) {
+		if goval.Kind() == reflect.Ptr && goval.IsNil() {
+			return lua.LNil, true, nil
+		}
+		marshaler = goval.Interface().(encoding.TextMarshaler)
+
+	} else if reflect.PtrTo(goval.Type()).Implements(textMarshalerType) {
+		// copy unaddressable values so that pointer-receiver MarshalText methods can be called
+		if !goval.CanAddr() {
+			ptr := reflect.New(goval.Type())
+			ptr.Elem().Set(goval)
+			goval = ptr.Elem()
+		}
+		marshaler = goval.Addr().Interface().(encoding.TextMarshaler)
+
+	} else {
+		return nil, false, nil
+	}
+
+	text, err := marshaler.MarshalText()
+	if err != nil {
+		return nil, true, err
+	}
+	return lua.LString(text), true, nil
+}
+
+// unmarshalText decodes an LString into a new value of type destType using its UnmarshalText method.
+// The returned bool is false if lv is not an LString or if neither destType nor a pointer to it
+// implements encoding.TextUnmarshaler.
+func unmarshalText(lv lua.LValue, destType reflect.Type) (reflect.Value, bool, error) {
+	str, is := lv.(lua.LString)
+	if !is || destType.Kind() == reflect.Interface {
+		return reflect.Value{}, false, nil
+	}
+
+	if reflect.PtrTo(destType).Implements(textUnmarshalerType) {
+		ptr := reflect.New(destType)
+		err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(str))
+		return ptr.Elem(), true, err
+
+	} else if destType.Kind() == reflect.Ptr && destType.Implements(textUnmarshalerType) {
+		ptr := reflect.New(destType.Elem())
+		err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(str))
+		return ptr, true, err
+	}
+
+	return reflect.Value{}, false, nil
+}
@@ -0,0 +1,180 @@
+package luaconv_test
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/yuin/gopher-lua"
+
+	"github.com/brynbellomy/go-luaconv"
+)
+
+type Cents int64
+
+func (c Cents) MarshalLua(L *lua.LState) (lua.LValue, error) {
+	return lua.LString(fmt.Sprintf("$%d.%02d", c/100, c%100)), nil
+}
+
+func (c *Cents) UnmarshalLua(lv lua.LValue) error {
+	var dollars, cents int64
+	_, err := fmt.Sscanf(lua.LVAsString(lv), "$%d.%02d", &dollars, &cents)
+	if err != nil {
+		return err
+	}
+	*c = Cents(dollars*100 + cents)
+	return nil
+}
+
+type GridCell struct {
+	Row, Col int
+}
+
+func (c GridCell) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d:%d", c.Row, c.Col)), nil
+}
+
+func (c *GridCell) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d:%d", &c.Row, &c.Col)
+	return err
+}
+
+type Invoice struct {
+	Total Cents `lua:"total"`
+}
+
+var _ = Describe("LuaMarshaler and LuaUnmarshaler", func() {
+	var L *lua.LState
+
+	BeforeEach(func() {
+		L = lua.NewState()
+	})
+
+	It("should be used by Encode", func() {
+		lv, err := luaconv.Encode(L, reflect.ValueOf(Invoice{Total: 1234}))
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		Expect(lv.(*lua.LTable).RawGetString("total")).To(Equal(lua.LString("$12.34")))
+	})
+
+	It("should be used by Wrap", func() {
+		lv, err := luaconv.Wrap(L, reflect.ValueOf(Cents(5)))
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		Expect(lv).To(Equal(lua.LString("$0.05")))
+	})
+
+	It("should be used by Decode for values and pointers", func() {
+		table := L.NewTable()
+		table.RawSetString("total", lua.LString("$12.34"))
+
+		nv, err := luaconv.Decode(L, table, reflect.TypeOf(Invoice{}))
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		Expect(nv.Interface()).To(Equal(Invoice{Total: 1234}))
+
+		nv, err = luaconv.Decode(L, lua.LString("$1.00"), reflect.TypeOf((*Cents)(nil)))
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		Expect(*nv.Interface().(*Cents)).To(Equal(Cents(100)))
+	})
+
+	It("should not be passed nil, which decodes to the zero value", func() {
+		nv, err := luaconv.Decode(L, lua.LNil, reflect.TypeOf(Cents(0)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nv.Interface()).To(Equal(Cents(0)))
+
+		nv, err = luaconv.Decode(L, lua.LNil, reflect.TypeOf((*Cents)(nil)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nv.IsNil()).To(BeTrue())
+	})
+
+	It("should return the error from UnmarshalLua", func() {
+		_, err := luaconv.Decode(L, lua.LString("twelve dollars"), reflect.TypeOf(Cents(0)))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should be used by Unwrap when calling wrapped functions", func() {
+		var got Cents
+		luafn, err := luaconv.Wrap(L, reflect.ValueOf(func(c Cents) {
+			got = c
+		}))
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		L.SetGlobal("thefunc", luafn)
+
+		err = L.DoString(`thefunc('$3.50')`)
+		if err != nil {
+			Fail(err.Error())
+		}
+
+		Expect(got).To(Equal(Cents(350)))
+	})
+})
+
+var _ = Describe("encoding.TextMarshaler and encoding.TextUnmarshaler", func() {
+	var L *lua.LState
+
+	BeforeEach(func() {
+		L = lua.NewState()
+	})
+
+	It("should be used by Encode to produce an LString", func() {
+		at := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		lv, err := luaconv.Encode(L, reflect.ValueOf(at))
+		if err != nil {
+			Fail(err.Error())
+		}
+		Expect(lv).To(Equal(lua.LString("2016-01-02T03:04:05Z")))
+
+		lv, err = luaconv.Encode(L, reflect.ValueOf(net.ParseIP("10.0.0.1")))
+		if err != nil {
+			Fail(err.Error())
+		}
+		Expect(lv).To(Equal(lua.LString("10.0.0.1")))
+	})
+
+	It("should be used by Decode when given an LString", func() {
+		nv, err := luaconv.Decode(L, lua.LString("2016-01-02T03:04:05Z"), reflect.TypeOf(time.Time{}))
+		if err != nil {
+			Fail(err.Error())
+		}
+		Expect(nv.Interface().(time.Time).Equal(time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC))).To(BeTrue())
+
+		nv, err = luaconv.Decode(L, lua.LString("10.0.0.1"), reflect.TypeOf(net.IP{}))
+		if err != nil {
+			Fail(err.Error())
+		}
+		Expect(nv.Interface()).To(Equal(net.ParseIP("10.0.0.1")))
+	})
+
+	It("should be used for map keys", func() {
+		cells := map[GridCell]string{{Row: 1, Col: 2}: "x"}
+
+		lv, err := luaconv.Encode(L, reflect.ValueOf(cells))
+		if err != nil {
+			Fail(err.Error())
+		}
+		Expect(lv.(*lua.LTable).RawGetString("1:2")).To(Equal(lua.LString("x")))
+
+		nv, err := luaconv.Decode(L, lv, reflect.TypeOf(cells))
+		if err != nil {
+			Fail(err.Error())
+		}
+		Expect(nv.Interface()).To(Equal(cells))
+	})
+})
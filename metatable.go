@@ -2,48 +2,101 @@ package luaconv
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 
 	"github.com/yuin/gopher-lua"
 )
 
-func metatableForStruct(L *lua.LState, val reflect.Value) *lua.LTable {
-	return metatableForValue(L, val, map[string]func(*lua.LState) int{
-		"__index":    structIndex,
-		"__newindex": structSetIndex,
+func (c *Converter) metatableForStruct(L *lua.LState, val reflect.Value) *lua.LTable {
+	return c.metatableForValue(L, val, map[string]func(*lua.LState) int{
+		"__index":    c.structIndex,
+		"__newindex": c.structSetIndex,
 		"__tostring": luaToString,
 	})
 }
 
-func metatableForArray(L *lua.LState, val reflect.Value) *lua.LTable {
-	return metatableForValue(L, val, map[string]func(*lua.LState) int{
-		"__index":    sliceIndex,
-		"__newindex": sliceSetIndex,
+func (c *Converter) metatableForArray(L *lua.LState, val reflect.Value) *lua.LTable {
+	metatable := c.metatableForValue(L, val, map[string]func(*lua.LState) int{
+		"__index":    c.sliceIndex,
+		"__newindex": c.sliceSetIndex,
 		"__len":      sliceLen,
 		"__tostring": luaToString,
 	})
+	c.setBuiltinMethods(L, metatable, map[string]func(*lua.LState) int{
+		"pairs":  c.slicePairs,
+		"ipairs": c.slicePairs,
+	})
+	return metatable
 }
 
-func metatableForSlice(L *lua.LState, val reflect.Value) *lua.LTable {
-	return metatableForValue(L, val, map[string]func(*lua.LState) int{
-		"__index":    sliceIndex,
-		"__newindex": sliceSetIndex,
+func (c *Converter) metatableForSlice(L *lua.LState, val reflect.Value) *lua.LTable {
+	metatable := c.metatableForValue(L, val, map[string]func(*lua.LState) int{
+		"__index":    c.sliceIndex,
+		"__newindex": c.sliceSetIndex,
 		"__len":      sliceLen,
 		"__tostring": luaToString,
 	})
+	c.setBuiltinMethods(L, metatable, map[string]func(*lua.LState) int{
+		"pairs":   c.slicePairs,
+		"ipairs":  c.slicePairs,
+		"append":  c.sliceAppend,
+		"insert":  c.sliceInsert,
+		"remove":  c.sliceRemove,
+		"slice":   c.sliceSlice,
+		"totable": c.sliceToTable,
+	})
+	return metatable
 }
 
-func metatableForMap(L *lua.LState, val reflect.Value) *lua.LTable {
-	return metatableForValue(L, val, map[string]func(*lua.LState) int{
-		"__index":    mapIndex,
-		"__newindex": mapSetIndex,
+func (c *Converter) metatableForMap(L *lua.LState, val reflect.Value) *lua.LTable {
+	metatable := c.metatableForValue(L, val, map[string]func(*lua.LState) int{
+		"__index":    c.mapIndex,
+		"__newindex": c.mapSetIndex,
 		"__len":      mapLen,
 		"__tostring": luaToString,
 	})
+	c.setBuiltinMethods(L, metatable, map[string]func(*lua.LState) int{
+		"pairs": c.mapPairs,
+	})
+	return metatable
 }
 
-func structIndex(L *lua.LState) int {
-	v := L.CheckUserData(1)
+// metatableForComplex returns the metatable shared by all wrapped complex numbers of the same type.
+// Sharing is necessary because Lua only calls __eq when both operands have the same metamethod.
+func (c *Converter) metatableForComplex(L *lua.LState, val reflect.Value) *lua.LTable {
+	key := fmt.Sprintf("luaconv.Converter(%p).%v", c, val.Type())
+	if metatable, is := L.GetTypeMetatable(key).(*lua.LTable); is {
+		return metatable
+	}
+
+	metatable := c.metatableForValue(L, val, map[string]func(*lua.LState) int{
+		"__index":    complexIndex,
+		"__add":      c.complexArith(func(a, b complex128) complex128 { return a + b }),
+		"__sub":      c.complexArith(func(a, b complex128) complex128 { return a - b }),
+		"__mul":      c.complexArith(func(a, b complex128) complex128 { return a * b }),
+		"__div":      c.complexArith(func(a, b complex128) complex128 { return a / b }),
+		"__unm":      c.complexUnm,
+		"__eq":       complexEq,
+		"__tostring": complexToString,
+	})
+	L.SetField(L.Get(lua.RegistryIndex), key, metatable)
+	return metatable
+}
+
+// checkValue returns the userdata at argument n along with the Go value it wraps, raising a Lua
+// error if the argument is not userdata created by Wrap.
+func checkValue(L *lua.LState, n int) (*lua.LUserData, reflect.Value) {
+	ud := L.CheckUserData(n)
+	rval, is := ud.Value.(reflect.Value)
+	if !is {
+		L.ArgError(n, "wrapped Go value expected")
+	}
+	return ud, rval
+}
+
+func (c *Converter) structIndex(L *lua.LState) int {
+	v, rval := checkValue(L, 1)
 	key := L.CheckString(2)
 
 	// check method set
@@ -54,13 +107,12 @@ func structIndex(L *lua.LState) int {
 	}
 
 	// check exported fields
-	rval := v.Value.(reflect.Value)
 	if rval.Type().Kind() == reflect.Ptr {
 		rval = rval.Elem()
 	}
 
 	fieldval := rval.FieldByName(key)
-	luafieldval, err := Wrap(L, fieldval)
+	luafieldval, err := c.Wrap(L, fieldval)
 	if err != nil {
 		L.RaiseError(err.Error())
 		return 0
@@ -70,20 +122,26 @@ func structIndex(L *lua.LState) int {
 	return 1
 }
 
-func structSetIndex(L *lua.LState) int {
-	v := L.CheckUserData(1)
+func (c *Converter) structSetIndex(L *lua.LState) int {
+	_, rval := checkValue(L, 1)
 	key := L.CheckString(2)
 	luaval := L.CheckAny(3)
 
 	// check exported fields
-	rval := v.Value.(reflect.Value)
 	if rval.Type().Kind() == reflect.Ptr {
 		rval = rval.Elem()
 	}
 
 	field := rval.FieldByName(key)
+	if !field.IsValid() {
+		L.RaiseError("Go type %v has no field '%v'", rval.Type(), key)
+		return 0
+	} else if !field.CanSet() {
+		L.RaiseError("cannot set field '%v' of Go type %v (wrap a pointer to make it settable)", key, rval.Type())
+		return 0
+	}
 
-	fieldval, err := Unwrap(luaval, field.Type())
+	fieldval, err := c.Unwrap(L, luaval, field.Type())
 	if err != nil {
 		L.RaiseError(err.Error())
 		return 0
@@ -93,8 +151,8 @@ func structSetIndex(L *lua.LState) int {
 	return 0
 }
 
-func sliceIndex(L *lua.LState) int {
-	v := L.CheckUserData(1)
+func (c *Converter) sliceIndex(L *lua.LState) int {
+	v, slice := checkSlice(L, 1)
 	arg2 := L.CheckAny(2)
 
 	if key, is := arg2.(lua.LString); is {
@@ -104,22 +162,24 @@ func sliceIndex(L *lua.LState) int {
 			L.Push(fn)
 			return 1
 		} else {
-			L.RaiseError("Method '%v' does not exist on Go type %T", string(key), v.Value.(reflect.Value).Interface())
+			L.RaiseError("Method '%v' does not exist on Go type %v", string(key), slice.Type())
 			return 0
 		}
 
 	} else if idx, is := arg2.(lua.LNumber); is {
-		// check slice indices
-		i := int(idx) - 1
-		slice := v.Value.(reflect.Value)
-		if i >= slice.Len() {
-			L.RaiseError("slice index %v out of range", idx)
+		// check slice indices.  As with tables, invalid indices read as nil.
+		i, ok := c.sliceOffset(idx, slice.Len())
+		if !ok && c.StrictIndexing {
+			L.RaiseError("slice index %v out of range (length %v)", idx, slice.Len())
 			return 0
+		} else if !ok {
+			L.Push(lua.LNil)
+			return 1
 		}
 
 		val := slice.Index(i)
 
-		luaval, err := Wrap(L, val)
+		luaval, err := c.Wrap(L, val)
 		if err != nil {
 			L.RaiseError(err.Error())
 			return 0
@@ -134,87 +194,313 @@ func sliceIndex(L *lua.LState) int {
 	}
 }
 
-func sliceSetIndex(L *lua.LState) int {
-	v := L.CheckUserData(1)
-	idx := L.CheckInt(2)
+func (c *Converter) sliceSetIndex(L *lua.LState) int {
+	v, slice := checkSlice(L, 1)
+	idx := L.CheckNumber(2)
 	luaval := L.CheckAny(3)
 
-	i := int(idx) - 1
-	slice := v.Value.(reflect.Value)
-	if i >= slice.Len() {
-		L.RaiseError("slice index %v out of range", idx)
+	// assigning just past the end of a slice appends to it, as with tables
+	i, ok := c.sliceOffset(idx, slice.Len())
+	appending := !ok && idx == lua.LNumber(slice.Len()+1) && slice.Kind() == reflect.Slice
+	if !ok && !appending {
+		L.RaiseError("slice index %v out of range (length %v)", idx, slice.Len())
+		return 0
+	} else if !appending && !slice.Index(i).CanSet() {
+		L.RaiseError("cannot set index %v of Go type %v (wrap a pointer to make it settable)", idx, slice.Type())
 		return 0
 	}
 
-	val, err := Unwrap(luaval, slice.Type().Elem())
+	val, err := c.Unwrap(L, luaval, slice.Type().Elem())
 	if err != nil {
 		L.RaiseError(err.Error())
 		return 0
 	}
 
-	idxval := slice.Index(i)
-	idxval.Set(val)
-
-	v.Value = slice
+	if appending {
+		setSlice(v, reflect.Append(slice, val))
+	} else {
+		slice.Index(i).Set(val)
+	}
 
 	return 0
 }
 
+// sliceOffset converts a Lua index into an offset into a slice or array of length n.  It returns false
+// if the index is not an integer or is out of range.  In NegativeIndexing mode, negative indices
+// count back from the end of the slice.
+func (c *Converter) sliceOffset(idx lua.LNumber, n int) (int, bool) {
+	f := float64(idx)
+	if f != math.Trunc(f) || math.Abs(f) > float64(n) {
+		return 0, false
+	}
+
+	i := int(f)
+	if i < 0 && c.NegativeIndexing {
+		i += n + 1
+	}
+
+	if i < 1 || i > n {
+		return 0, false
+	}
+	return i - 1, true
+}
+
 func sliceLen(L *lua.LState) int {
-	v := L.CheckUserData(1)
-	slice := v.Value.(reflect.Value)
+	_, slice := checkSlice(L, 1)
 	L.Push(lua.LNumber(slice.Len()))
 	return 1
 }
 
-func mapIndex(L *lua.LState) int {
-	v := L.CheckUserData(1)
-	m := v.Value.(reflect.Value)
-	key := L.CheckString(2)
-	val := m.MapIndex(reflect.ValueOf(key))
+// checkMap returns the userdata at argument n along with the map it wraps.  Userdata created by
+// wrapping a pointer to a map wraps the pointer, which is dereferenced.
+func checkMap(L *lua.LState, n int) (*lua.LUserData, reflect.Value) {
+	ud, rval := checkValue(L, n)
+	rval = reflect.Indirect(rval)
 
-	luaval, err := Wrap(L, val)
-	if err != nil {
-		L.RaiseError(err.Error())
-		return 0
+	if rval.Kind() != reflect.Map {
+		L.ArgError(n, "wrapped Go map expected")
 	}
+	return ud, rval
+}
 
-	L.Push(luaval)
-	return 1
+func (c *Converter) mapIndex(L *lua.LState) int {
+	v, m := checkMap(L, 1)
+	luakey := L.CheckAny(2)
+
+	// a key that can't be converted to the map's key type can't be in the map
+	if gokey, err := c.unwrapMapKey(L, luakey, m.Type().Key()); err == nil {
+		if val := m.MapIndex(gokey); val.IsValid() {
+			luaval, err := c.Wrap(L, val)
+			if err != nil {
+				L.RaiseError(err.Error())
+				return 0
+			}
+
+			L.Push(luaval)
+			return 1
+		}
+	}
 
+	// fall back to the method set for keys that aren't in the map
+	if name, is := luakey.(lua.LString); is {
+		methods := v.Metatable.(*lua.LTable).RawGetString("methods").(*lua.LTable)
+		if fn := methods.RawGetString(string(name)); fn != lua.LNil {
+			L.Push(fn)
+			return 1
+		}
+	}
+
+	L.Push(lua.LNil)
+	return 1
 }
 
-func mapSetIndex(L *lua.LState) int {
-	v := L.CheckUserData(1)
-	m := v.Value.(reflect.Value)
+func (c *Converter) mapSetIndex(L *lua.LState) int {
+	_, m := checkMap(L, 1)
 	luakey := L.CheckAny(2)
 	luaval := L.CheckAny(3)
 
-	gokey, err := Unwrap(luakey, m.Type().Key())
+	gokey, err := c.unwrapMapKey(L, luakey, m.Type().Key())
 	if err != nil {
 		L.RaiseError(err.Error())
 		return 0
 	}
 
-	goval, err := Unwrap(luaval, m.Type().Elem())
+	// assigning nil deletes the entry, as with tables
+	if luaval == lua.LNil {
+		m.SetMapIndex(gokey, reflect.Value{})
+		return 0
+	}
+
+	goval, err := c.Unwrap(L, luaval, m.Type().Elem())
 	if err != nil {
 		L.RaiseError(err.Error())
 		return 0
 	}
 
+	// a nil map wrapped via a pointer can be allocated, as Go code would
+	if m.IsNil() && m.CanSet() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+
 	m.SetMapIndex(gokey, goval)
 
 	return 0
 }
 
+// slicePairs implements `pairs` and `ipairs` for wrapped slices and arrays.  Like `ipairs` for
+// tables, it returns an iterator function, the userdata itself and 0.
+func (c *Converter) slicePairs(L *lua.LState) int {
+	v, _ := checkSlice(L, 1)
+	L.Push(L.NewFunction(c.protect(c.sliceNext)))
+	L.Push(v)
+	L.Push(lua.LNumber(0))
+	return 3
+}
+
+// sliceNext is the iterator function returned by slicePairs.  Given the userdata and the previous
+// index, it returns the next index and element, or nil at the end of the slice.
+func (c *Converter) sliceNext(L *lua.LState) int {
+	_, slice := checkSlice(L, 1)
+	i := L.CheckInt(2)
+
+	if i < 0 || i >= slice.Len() {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	luaval, err := c.Wrap(L, slice.Index(i))
+	if err != nil {
+		L.RaiseError(err.Error())
+		return 0
+	}
+
+	L.Push(lua.LNumber(i + 1))
+	L.Push(luaval)
+	return 2
+}
+
+// mapPairs implements `pairs` for wrapped maps.  The keys are collected when iteration begins (and
+// sorted if SortMapKeys is set); entries that are deleted during iteration are skipped.
+func (c *Converter) mapPairs(L *lua.LState) int {
+	v, m := checkMap(L, 1)
+
+	keys := m.MapKeys()
+	if c.SortMapKeys {
+		sortMapKeys(keys)
+	}
+
+	next := func(L *lua.LState) int {
+		for len(keys) > 0 {
+			key := keys[0]
+			keys = keys[1:]
+
+			val := m.MapIndex(key)
+			if !val.IsValid() {
+				continue
+			}
+
+			luakey, err := c.Wrap(L, key)
+			if err != nil {
+				L.RaiseError(err.Error())
+				return 0
+			}
+
+			luaval, err := c.Wrap(L, val)
+			if err != nil {
+				L.RaiseError(err.Error())
+				return 0
+			}
+
+			L.Push(luakey)
+			L.Push(luaval)
+			return 2
+		}
+
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	L.Push(L.NewFunction(c.protect(next)))
+	L.Push(v)
+	L.Push(lua.LNil)
+	return 3
+}
+
 func mapLen(L *lua.LState) int {
-	v := L.CheckUserData(1)
-	m := v.Value.(reflect.Value)
+	_, m := checkMap(L, 1)
 	L.Push(lua.LNumber(m.Len()))
 	return 1
 }
 
-func metatableForValue(L *lua.LState, val reflect.Value, metamethods map[string]func(*lua.LState) int) *lua.LTable {
+// checkComplex returns the value of argument n, which must be a wrapped complex number or a Lua
+// number, as a complex128.  It also returns the Go type of wrapped complex numbers, or nil for Lua
+// numbers.
+func checkComplex(L *lua.LState, n int) (complex128, reflect.Type) {
+	switch lv := L.Get(n).(type) {
+	case lua.LNumber:
+		return complex(float64(lv), 0), nil
+
+	case *lua.LUserData:
+		if rval, is := lv.Value.(reflect.Value); is {
+			switch rval.Kind() {
+			case reflect.Complex64, reflect.Complex128:
+				return rval.Complex(), rval.Type()
+			}
+		}
+	}
+
+	L.ArgError(n, "complex number expected")
+	return 0, nil
+}
+
+func complexIndex(L *lua.LState) int {
+	v := L.CheckUserData(1)
+	key := L.CheckString(2)
+	cplx, _ := checkComplex(L, 1)
+
+	switch key {
+	case "re":
+		L.Push(lua.LNumber(real(cplx)))
+	case "im":
+		L.Push(lua.LNumber(imag(cplx)))
+	default:
+		// check method set
+		methods := v.Metatable.(*lua.LTable).RawGetString("methods").(*lua.LTable)
+		L.Push(methods.RawGetString(key))
+	}
+	return 1
+}
+
+// complexArith returns a metamethod that applies `op` to its operands.  The result has the same Go
+// type as the wrapped complex operand.
+func (c *Converter) complexArith(op func(a, b complex128) complex128) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		a, atype := checkComplex(L, 1)
+		b, btype := checkComplex(L, 2)
+
+		ctype := atype
+		if ctype == nil {
+			ctype = btype
+		}
+
+		result, err := c.Wrap(L, reflect.ValueOf(op(a, b)).Convert(ctype))
+		if err != nil {
+			L.RaiseError(err.Error())
+			return 0
+		}
+
+		L.Push(result)
+		return 1
+	}
+}
+
+func (c *Converter) complexUnm(L *lua.LState) int {
+	cplx, ctype := checkComplex(L, 1)
+
+	result, err := c.Wrap(L, reflect.ValueOf(-cplx).Convert(ctype))
+	if err != nil {
+		L.RaiseError(err.Error())
+		return 0
+	}
+
+	L.Push(result)
+	return 1
+}
+
+func complexEq(L *lua.LState) int {
+	a, _ := checkComplex(L, 1)
+	b, _ := checkComplex(L, 2)
+	L.Push(lua.LBool(a == b))
+	return 1
+}
+
+func complexToString(L *lua.LState) int {
+	_, rval := checkValue(L, 1)
+	L.Push(lua.LString(fmt.Sprint(rval.Interface())))
+	return 1
+}
+
+func (c *Converter) metatableForValue(L *lua.LState, val reflect.Value, metamethods map[string]func(*lua.LState) int) *lua.LTable {
 	if !val.IsValid() {
 		return nil
 	}
@@ -233,19 +519,34 @@ func metatableForValue(L *lua.LState, val reflect.Value, metamethods map[string]
 	}
 
 	metatable := L.NewTable()
-	metatable.RawSetString("methods", methodsetForType(vtype).toLuaTable(L))
+	metatable.RawSetString("methods", c.methodsets.Load(vtype).toLuaTable(L))
 	for key, method := range metamethods {
-		metatable.RawSetString(key, L.NewFunction(method))
+		metatable.RawSetString(key, L.NewFunction(c.protect(method)))
 	}
 
 	return metatable
 }
 
+// setBuiltinMethods adds methods implemented by luaconv, such as `pairs`, to a metatable's method
+// set.  Their names are lowercase, so they cannot collide with the Go value's own methods.
+func (c *Converter) setBuiltinMethods(L *lua.LState, metatable *lua.LTable, builtins map[string]func(*lua.LState) int) {
+	if metatable == nil {
+		return
+	}
+
+	methods := metatable.RawGetString("methods").(*lua.LTable)
+	for name, fn := range builtins {
+		methods.RawSetString(name, L.NewFunction(c.protect(fn)))
+	}
+}
+
 func luaToString(L *lua.LState) int {
-	ud := L.CheckUserData(1)
-	value := ud.Value.(reflect.Value).Interface()
+	_, rval := checkValue(L, 1)
+	value := rval.Interface()
 	if s, ok := value.(fmt.Stringer); ok {
 		L.Push(lua.LString(s.String()))
+	} else if err, ok := value.(error); ok {
+		L.Push(lua.LString(err.Error()))
 	} else {
 		L.Push(lua.LString(fmt.Sprintf("userdata([%T] %v)", value, value)))
 	}
@@ -0,0 +1,180 @@
+package luaconv
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// checkSlice returns the userdata at argument n along with the slice or array it wraps.  Userdata
+// created by wrapping a pointer to a slice or array wraps the pointer, which is dereferenced.
+func checkSlice(L *lua.LState, n int) (*lua.LUserData, reflect.Value) {
+	ud, rval := checkValue(L, n)
+	rval = reflect.Indirect(rval)
+
+	switch rval.Kind() {
+	case reflect.Slice, reflect.Array:
+	default:
+		L.ArgError(n, "wrapped Go slice or array expected")
+	}
+	return ud, rval
+}
+
+// setSlice stores a slice that has been resized back into the userdata `v`.  If the userdata wraps a
+// pointer to a slice, or a settable slice such as a field of a struct wrapped via a pointer, that
+// slice is updated, so that the change is visible to Go.  Otherwise, only the userdata sees the new
+// slice, just as with a slice passed by value in Go.
+func setSlice(v *lua.LUserData, slice reflect.Value) {
+	rval := v.Value.(reflect.Value)
+
+	switch {
+	case rval.Kind() == reflect.Ptr:
+		rval.Elem().Set(slice)
+	case rval.CanSet():
+		rval.Set(slice)
+	default:
+		v.Value = slice
+	}
+}
+
+// checkPosition returns argument `arg` as a position into a slice of length n, raising an error
+// unless it is an integer from lo through hi.  In NegativeIndexing mode, negative positions count
+// back from the end of the slice, as they do for indexing.
+func (c *Converter) checkPosition(L *lua.LState, arg int, n int, lo int, hi int) int {
+	f := float64(L.CheckNumber(arg))
+	if f != math.Trunc(f) || math.Abs(f) > float64(n+1) {
+		L.ArgError(arg, "position out of bounds")
+		return 0
+	}
+
+	i := int(f)
+	if i < 0 && c.NegativeIndexing {
+		i += n + 1
+	}
+
+	if i < lo || i > hi {
+		L.ArgError(arg, "position out of bounds")
+		return 0
+	}
+	return i
+}
+
+// sliceAppend implements `s:append(...)`, which appends each of its arguments to the slice.
+func (c *Converter) sliceAppend(L *lua.LState) int {
+	v, slice := checkSlice(L, 1)
+
+	for i := 2; i <= L.GetTop(); i++ {
+		val, err := c.Unwrap(L, L.Get(i), slice.Type().Elem())
+		if err != nil {
+			L.RaiseError(err.Error())
+			return 0
+		}
+		slice = reflect.Append(slice, val)
+	}
+
+	setSlice(v, slice)
+	return 0
+}
+
+// sliceInsert implements `s:insert(i, value)`, which inserts a value at index i, shifting up the
+// elements after it.  Like table.insert, `s:insert(value)` appends the value.
+func (c *Converter) sliceInsert(L *lua.LState) int {
+	v, slice := checkSlice(L, 1)
+
+	var i int
+	var luaval lua.LValue
+	switch L.GetTop() {
+	case 2:
+		i, luaval = slice.Len()+1, L.Get(2)
+	case 3:
+		i, luaval = c.checkPosition(L, 2, slice.Len(), 1, slice.Len()+1), L.Get(3)
+	default:
+		L.RaiseError("wrong number of arguments to 'insert'")
+		return 0
+	}
+
+	val, err := c.Unwrap(L, luaval, slice.Type().Elem())
+	if err != nil {
+		L.RaiseError(err.Error())
+		return 0
+	}
+
+	slice = reflect.Append(slice, reflect.Zero(slice.Type().Elem()))
+	reflect.Copy(slice.Slice(i, slice.Len()), slice.Slice(i-1, slice.Len()-1))
+	slice.Index(i - 1).Set(val)
+
+	setSlice(v, slice)
+	return 0
+}
+
+// sliceRemove implements `s:remove([i])`, which removes and returns the element at index i (by
+// default, the last element), shifting down the elements after it.
+func (c *Converter) sliceRemove(L *lua.LState) int {
+	v, slice := checkSlice(L, 1)
+	n := slice.Len()
+
+	if n == 0 && L.GetTop() < 2 {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	i := n
+	if L.GetTop() >= 2 {
+		i = c.checkPosition(L, 2, n, 1, n)
+	}
+
+	// copy the element, since the slice's array is about to be overwritten
+	removed := reflect.New(slice.Type().Elem()).Elem()
+	removed.Set(slice.Index(i - 1))
+
+	reflect.Copy(slice.Slice(i-1, n), slice.Slice(i, n))
+	slice.Index(n - 1).Set(reflect.Zero(slice.Type().Elem()))
+	setSlice(v, slice.Slice(0, n-1))
+
+	luaval, err := c.Wrap(L, removed)
+	if err != nil {
+		L.RaiseError(err.Error())
+		return 0
+	}
+
+	L.Push(luaval)
+	return 1
+}
+
+// sliceSlice implements `s:slice(i, [j])`, which returns the elements from index i through j
+// (inclusive, as with string.sub) as a new wrapped slice.  Like a Go subslice, it shares the
+// original slice's array.
+func (c *Converter) sliceSlice(L *lua.LState) int {
+	_, slice := checkSlice(L, 1)
+	n := slice.Len()
+
+	i := c.checkPosition(L, 2, n, 1, n+1)
+	j := n
+	if L.GetTop() >= 3 {
+		j = c.checkPosition(L, 3, n, i-1, n)
+	}
+
+	luaval, err := c.Wrap(L, slice.Slice(i-1, j))
+	if err != nil {
+		L.RaiseError(err.Error())
+		return 0
+	}
+
+	L.Push(luaval)
+	return 1
+}
+
+// sliceToTable implements `s:totable()`, which copies the slice into a Lua table as with Encode.
+func (c *Converter) sliceToTable(L *lua.LState) int {
+	_, slice := checkSlice(L, 1)
+
+	table, err := c.Encode(L, slice)
+	if err != nil {
+		L.RaiseError(err.Error())
+		return 0
+	}
+
+	L.Push(table)
+	return 1
+}
@@ -11,21 +11,17 @@ type (
 	methodsetCache struct {
 		mutex      sync.RWMutex
 		methodsets map[reflect.Type]methodset
+		conv       *Converter
 	}
 
 	methodset map[string]func(*lua.LState) int
 )
 
-func methodsetForType(vtype reflect.Type) methodset {
-	return _methodsetCache.Load(vtype)
-}
-
-var _methodsetCache = newMethodsetCache()
-
-func newMethodsetCache() *methodsetCache {
+func newMethodsetCache(conv *Converter) *methodsetCache {
 	return &methodsetCache{
 		mutex:      sync.RWMutex{},
 		methodsets: map[reflect.Type]methodset{},
+		conv:       conv,
 	}
 }
 
@@ -53,14 +49,14 @@ func (c *methodsetCache) create(vtype reflect.Type) methodset {
 		ptrType := reflect.PtrTo(vtype)
 		for i := 0; i < ptrType.NumMethod(); i++ {
 			m := ptrType.Method(i)
-			luafn := wrapFunc(m.Func)
+			luafn := c.conv.wrapFunc(m.Func)
 			ms[m.Name] = luafn
 		}
 	}
 
 	for i := 0; i < vtype.NumMethod(); i++ {
 		m := vtype.Method(i)
-		luafn := wrapFunc(m.Func)
+		luafn := c.conv.wrapFunc(m.Func)
 		ms[m.Name] = luafn
 	}
 
@@ -1,6 +1,13 @@
 package luaconv
 
 import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/yuin/gopher-lua"
 )
 
@@ -17,3 +24,120 @@ func getLuaTableData(table *lua.LTable) []luaTableData {
 
 	return tableData
 }
+
+// parseLuaNumber parses a string as Lua converts strings to numbers: a decimal number, or a
+// hexadecimal integer with a 0x prefix, either of which may be signed.  Unlike strconv.ParseFloat,
+// it rejects spellings of infinity and NaN.
+func parseLuaNumber(str string) (float64, error) {
+	digits := strings.TrimLeft(str, "+-")
+	if len(str)-len(digits) > 1 {
+		return 0, fmt.Errorf("invalid number %q", str)
+	}
+
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		u, err := strconv.ParseUint(digits[2:], 16, 64)
+		if err != nil && !isRangeError(err) {
+			return 0, fmt.Errorf("invalid number %q", str)
+		}
+		f := float64(u)
+		if strings.HasPrefix(str, "-") {
+			f = -f
+		}
+		return f, nil
+	}
+
+	if strings.IndexFunc(digits, func(r rune) bool { return !strings.ContainsRune("0123456789.eE+-", r) }) >= 0 {
+		return 0, fmt.Errorf("invalid number %q", str)
+	}
+
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil && !isRangeError(err) {
+		return 0, fmt.Errorf("invalid number %q", str)
+	}
+	return f, nil
+}
+
+// isRangeError returns true if `err` is a strconv error for a number too large to be represented.
+func isRangeError(err error) bool {
+	numErr, is := err.(*strconv.NumError)
+	return is && numErr.Err == strconv.ErrRange
+}
+
+// countLuaTableEntries returns the number of non-nil entries in a table, without copying them.
+func countLuaTableEntries(table *lua.LTable) int {
+	n := 0
+	table.ForEach(func(_, _ lua.LValue) {
+		n++
+	})
+	return n
+}
+
+// measureArray returns the length of a table's array part according to `shape`, and whether the
+// table has any keys outside of it.
+func measureArray(table *lua.LTable, shape TableShape) (n int, others bool) {
+	if shape == TableShapeStrict {
+		for table.RawGetInt(n+1) != lua.LNil {
+			n++
+		}
+	} else {
+		n = table.MaxN()
+	}
+
+	table.ForEach(func(key, _ lua.LValue) {
+		if i, is := key.(lua.LNumber); is && i >= 1 && i <= lua.LNumber(n) && float64(i) == math.Trunc(float64(i)) {
+			return
+		}
+		others = true
+	})
+
+	return n, others
+}
+
+// hasOnlyStringKeys returns true if every key of `table` is a string.
+func hasOnlyStringKeys(table *lua.LTable) bool {
+	only := true
+	table.ForEach(func(key, _ lua.LValue) {
+		if _, is := key.(lua.LString); !is {
+			only = false
+		}
+	})
+	return only
+}
+
+// sortMapKeys sorts map keys into a deterministic order.  Numbers, strings and bools are compared by
+// value, keys of different kinds by kind, and anything else by its fmt.Sprint representation.
+func sortMapKeys(keys []reflect.Value) {
+	sort.Slice(keys, func(i, j int) bool {
+		return mapKeyLess(keys[i], keys[j])
+	})
+}
+
+func mapKeyLess(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+
+	if a.Kind() != b.Kind() {
+		return a.Kind() < b.Kind()
+	}
+
+	switch a.Kind() {
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.Invalid:
+		return false
+	default:
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	}
+}
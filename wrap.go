@@ -7,19 +7,52 @@ import (
 	"github.com/yuin/gopher-lua"
 )
 
+// ErrorMode determines how a trailing, non-nil `error` return value from a wrapped Go function is
+// surfaced to Lua.
+type ErrorMode int
+
+const (
+	// ErrorModeReturn makes the wrapped function return the idiomatic Lua `nil, "message"` pair,
+	// followed by the error value itself wrapped as userdata.
+	ErrorModeReturn ErrorMode = iota
+
+	// ErrorModeRaise makes the wrapped function raise a Lua error containing the error message.
+	ErrorModeRaise
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Wrap converts a Go value into a Lua value using DefaultConverter.
 func Wrap(L *lua.LState, goval reflect.Value) (lua.LValue, error) {
+	return DefaultConverter.Wrap(L, goval)
+}
+
+// Wrap converts a Go value into a Lua value.  Unlike Encode, structs, maps, slices and arrays are not
+// copied, but are instead wrapped in userdata that proxies field/index access and method calls to
+// the underlying Go value.
+func (c *Converter) Wrap(L *lua.LState, goval reflect.Value) (lv lua.LValue, err error) {
+	defer c.recoverPanic("Wrap", &err)
+
 	if !goval.IsValid() {
 		return lua.LNil, nil
 	}
 
-	return wrapAs(L, goval, goval.Type())
+	return c.wrapAs(L, goval, goval.Type())
 }
 
-func wrapAs(L *lua.LState, goval reflect.Value, wraptype reflect.Type) (lua.LValue, error) {
+func (c *Converter) wrapAs(L *lua.LState, goval reflect.Value, wraptype reflect.Type) (lua.LValue, error) {
 	if !goval.IsValid() {
 		return lua.LNil, nil
 	}
 
+	if encode := c.encoderFor(goval.Type()); encode != nil {
+		return encode(L, goval)
+	}
+
+	if lv, is, err := marshalLua(L, goval); is {
+		return lv, err
+	}
+
 	switch wraptype.Kind() {
 	// nils are passed through
 	case reflect.Invalid:
@@ -31,13 +64,13 @@ func wrapAs(L *lua.LState, goval reflect.Value, wraptype reflect.Type) (lua.LVal
 			return lua.LNil, nil
 		}
 		elemVal := goval.Elem()
-		return wrapAs(L, elemVal, elemVal.Type())
+		return c.wrapAs(L, elemVal, elemVal.Type())
 
 	case reflect.Ptr:
 		if goval.IsNil() {
 			return lua.LNil, nil
 		}
-		return wrapAs(L, goval, wraptype.Elem())
+		return c.wrapAs(L, goval, wraptype.Elem())
 
 	case reflect.Bool:
 		return lua.LBool(goval.Bool()), nil
@@ -47,14 +80,14 @@ func wrapAs(L *lua.LState, goval reflect.Value, wraptype reflect.Type) (lua.LVal
 		reflect.Int16,
 		reflect.Int32,
 		reflect.Int64:
-		return lua.LNumber(goval.Int()), nil
+		return c.encodeInt(goval.Int()), nil
 
 	case reflect.Uint,
 		reflect.Uint8,
 		reflect.Uint16,
 		reflect.Uint32,
 		reflect.Uint64:
-		return lua.LNumber(goval.Uint()), nil
+		return c.encodeUint(goval.Uint()), nil
 
 	case reflect.Float32, reflect.Float64:
 		return lua.LNumber(goval.Float()), nil
@@ -63,27 +96,35 @@ func wrapAs(L *lua.LState, goval reflect.Value, wraptype reflect.Type) (lua.LVal
 		return lua.LString(goval.String()), nil
 
 	case reflect.Complex64, reflect.Complex128:
-		return nil, fmt.Errorf("luaconv.Wrap: cannot convert %v to lua value", wraptype.String())
+		ud := L.NewUserData()
+		ud.Value = reflect.Indirect(goval)
+		ud.Metatable = c.metatableForComplex(L, reflect.Indirect(goval))
+		return ud, nil
 
 	case reflect.Struct:
 		ud := L.NewUserData()
 		ud.Value = goval
-		ud.Metatable = metatableForStruct(L, goval)
+		ud.Metatable = c.metatableForStruct(L, goval)
 		return ud, nil
 
 	case reflect.Slice:
 		if goval.IsNil() {
 			return lua.LNil, nil
+		} else if wraptype.Elem().Kind() == reflect.Uint8 {
+			return lua.LString(goBytes(reflect.Indirect(goval))), nil
 		}
 		ud := L.NewUserData()
 		ud.Value = goval
-		ud.Metatable = metatableForSlice(L, goval)
+		ud.Metatable = c.metatableForSlice(L, goval)
 		return ud, nil
 
 	case reflect.Array:
+		if wraptype.Elem().Kind() == reflect.Uint8 {
+			return lua.LString(goBytes(reflect.Indirect(goval))), nil
+		}
 		ud := L.NewUserData()
 		ud.Value = goval
-		ud.Metatable = metatableForArray(L, goval)
+		ud.Metatable = c.metatableForArray(L, goval)
 		return ud, nil
 
 	case reflect.Map:
@@ -92,35 +133,45 @@ func wrapAs(L *lua.LState, goval reflect.Value, wraptype reflect.Type) (lua.LVal
 		}
 		ud := L.NewUserData()
 		ud.Value = goval
-		ud.Metatable = metatableForMap(L, goval)
+		ud.Metatable = c.metatableForMap(L, goval)
 		return ud, nil
 
 	case reflect.Func:
 		if goval.IsNil() {
 			return lua.LNil, nil
 		}
-		return L.NewFunction(wrapFunc(goval)), nil
+		return L.NewFunction(c.wrapFunc(goval)), nil
 
 	default:
 		return nil, fmt.Errorf("luaconv.Wrap: cannot convert %v to lua value", wraptype.String())
 	}
 }
 
-func wrapFunc(fnval reflect.Value) func(*lua.LState) int {
+func (c *Converter) wrapFunc(fnval reflect.Value) func(*lua.LState) int {
 	fntype := fnval.Type()
 	numIn := fntype.NumIn()
 	numOut := fntype.NumOut()
+	isVariadic := fntype.IsVariadic()
+	returnsError := numOut > 0 && fntype.Out(numOut-1) == errorType
+
+	// the trailing parameter of a variadic function is a slice that collects any remaining Lua args
+	numFixedIn := numIn
+	if isVariadic {
+		numFixedIn = numIn - 1
+	}
 
-	return func(L *lua.LState) int {
+	return c.protect(func(L *lua.LState) int {
 		luaNumIn := L.GetTop()
-		if luaNumIn != numIn {
+		if isVariadic && luaNumIn < numFixedIn {
+			L.RaiseError("expected at least %v args, got %v", numFixedIn, luaNumIn)
+		} else if !isVariadic && luaNumIn != numIn {
 			L.RaiseError("expected %v args, got %v", numIn, luaNumIn)
 		}
 
 		args := make([]reflect.Value, numIn)
-		for i := 0; i < luaNumIn; i++ {
+		for i := 0; i < numFixedIn; i++ {
 			luaval := L.Get(i + 1)
-			arg, err := Unwrap(luaval, fntype.In(i))
+			arg, err := c.Unwrap(L, luaval, fntype.In(i))
 			if err != nil {
 				L.RaiseError(err.Error())
 			}
@@ -128,200 +179,130 @@ func wrapFunc(fnval reflect.Value) func(*lua.LState) int {
 			args[i] = arg
 		}
 
-		rets := fnval.Call(args)
-		if len(rets) != numOut {
-			L.RaiseError("expected %v return values, got %v", numOut, len(rets))
-		}
-
-		for i := range rets {
-			luaval, err := Wrap(L, rets[i])
-			if err != nil {
-				L.RaiseError(err.Error())
-			}
-			L.Push(luaval)
-		}
-
-		return numOut
-	}
-}
-
-func Unwrap(lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
-	if lv == lua.LNil {
-		return reflect.Value{}, nil
-	}
-
-	// unwrapping is basically a no-op unless we encounter native Lua values, which we have to decode
-
-	if ud, is := lv.(*lua.LUserData); is {
-		rval := ud.Value.(reflect.Value)
-		rtype := rval.Type()
+		var rets []reflect.Value
+		if isVariadic {
+			varargsType := fntype.In(numIn - 1)
+			varargs := reflect.MakeSlice(varargsType, luaNumIn-numFixedIn, luaNumIn-numFixedIn)
 
-		if rtype == destType {
-			return rval, nil
-		} else if rtype.ConvertibleTo(destType) {
-			return rval.Convert(destType), nil
-		} else if destType == reflect.PtrTo(rtype) && rval.CanAddr() {
-			return rval.Addr(), nil
-		} else {
-			if !rval.CanAddr() {
-				fmt.Println("NOT rval.CanAddr")
-			}
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert userdata(%v) to %v", rtype, destType.String())
-		}
-	}
-
-	switch destType.Kind() {
-	case reflect.Interface:
-		switch lv := lv.(type) {
-		case lua.LString:
-			return Unwrap(lv, stringType)
-		case lua.LNumber:
-			return Unwrap(lv, numberType)
-		case lua.LBool:
-			return Unwrap(lv, boolType)
-		case *lua.LTable:
-			if lv.MaxN() > 0 {
-				return Unwrap(lv, sliceType)
-			} else {
-				return Unwrap(lv, mapType)
-			}
-		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
-		}
+			for i := numFixedIn; i < luaNumIn; i++ {
+				luaval := L.Get(i + 1)
+				arg, err := c.Unwrap(L, luaval, varargsType.Elem())
+				if err != nil {
+					L.RaiseError(err.Error())
+				}
 
-	case reflect.String:
-		switch lv := lv.(type) {
-		case lua.LString:
-			strval := reflect.ValueOf(string(lv))
-
-			if strval.Type() == destType {
-				return strval, nil
-			} else if strval.Type().ConvertibleTo(destType) {
-				return strval.Convert(destType), nil
-			} else {
-				return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
+				varargs.Index(i - numFixedIn).Set(arg)
 			}
 
-		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
+			args[numIn-1] = varargs
+			rets = fnval.CallSlice(args)
+		} else {
+			rets = fnval.Call(args)
 		}
 
-	case reflect.Int,
-		reflect.Int8,
-		reflect.Int16,
-		reflect.Int32,
-		reflect.Int64,
-		reflect.Uint,
-		reflect.Uint8,
-		reflect.Uint16,
-		reflect.Uint32,
-		reflect.Uint64,
-		reflect.Float32,
-		reflect.Float64:
-
-		switch lv := lv.(type) {
-		case lua.LNumber:
-			return reflect.ValueOf(float64(lv)).Convert(destType), nil
-		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
+		if len(rets) != numOut {
+			L.RaiseError("expected %v return values, got %v", numOut, len(rets))
 		}
 
-	case reflect.Complex64, reflect.Complex128:
-		return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert to/from complex64 or complex128")
-
-	case reflect.Slice:
-		table, is := lv.(*lua.LTable)
-		if !is {
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
+		if returnsError && !rets[numOut-1].IsNil() {
+			return c.pushFuncError(L, rets[numOut-1])
 		}
 
-		maxn := table.MaxN()
-		slice := reflect.MakeSlice(destType, maxn, maxn)
-
-		for i := 0; i < maxn; i++ {
-			luaVal := table.RawGet(lua.LNumber(i + 1))
-
-			x, err := Unwrap(luaVal, destType.Elem())
+		for i := range rets {
+			luaval, err := c.Wrap(L, rets[i])
 			if err != nil {
-				return reflect.Value{}, err
+				L.RaiseError(err.Error())
 			}
-
-			slice.Index(i).Set(x)
-		}
-
-		return slice, nil
-
-	case reflect.Array:
-		table, is := lv.(*lua.LTable)
-		if !is {
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
+			L.Push(luaval)
 		}
 
-		maxn := table.MaxN()
-		array := reflect.New(destType).Elem()
-
-		for i := 0; i < maxn; i++ {
-			luaVal := table.RawGet(lua.LNumber(i + 1))
-
-			x, err := Unwrap(luaVal, destType.Elem())
-			if err != nil {
-				return reflect.Value{}, err
-			}
+		return numOut
+	})
+}
 
-			array.Index(i).Set(x)
-		}
+func (c *Converter) pushFuncError(L *lua.LState, errval reflect.Value) int {
+	msg := errval.Interface().(error).Error()
 
-		return array, nil
+	switch c.ErrorMode {
+	case ErrorModeRaise:
+		L.RaiseError(msg)
+		return 0
 
-	case reflect.Map:
-		table, is := lv.(*lua.LTable)
-		if !is {
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
+	default:
+		luaerr, err := c.Wrap(L, errval)
+		if err != nil {
+			L.RaiseError(err.Error())
+			return 0
 		}
 
-		aMap := reflect.MakeMap(destType)
-		tableData := getLuaTableData(table)
-		destTypeKey := destType.Key()
-		destTypeElem := destType.Elem()
+		L.Push(lua.LNil)
+		L.Push(lua.LString(msg))
+		L.Push(luaerr)
+		return 3
+	}
+}
 
-		for _, x := range tableData {
-			nvkey, err := Unwrap(x.key, destTypeKey)
-			if err != nil {
-				return reflect.Value{}, err
-			}
+// Unwrap converts a Lua value into a Go value of type `destType` using DefaultConverter.
+func Unwrap(L *lua.LState, lv lua.LValue, destType reflect.Type) (reflect.Value, error) {
+	return DefaultConverter.Unwrap(L, lv, destType)
+}
 
-			nvval, err := Unwrap(x.val, destTypeElem)
-			if err != nil {
-				return reflect.Value{}, err
-			}
+// Unwrap converts a Lua value into a Go value of type `destType`.  Userdata created by Wrap is
+// unwrapped to the Go value it contains.  Native Lua values are decoded as with Decode, except that
+// Lua functions are converted into Go functions that Wrap their arguments rather than Encode them.
+func (c *Converter) Unwrap(L *lua.LState, lv lua.LValue, destType reflect.Type) (rval reflect.Value, err error) {
+	defer c.recoverPanic("Unwrap", &err)
 
-			aMap.SetMapIndex(nvkey, nvval)
-		}
+	d := c.newDecoder(L, "Unwrap")
+	d.wrapFuncs = true
+	rval, err = d.decode(lv, destType)
+	return rval, d.result(err)
+}
 
-		return aMap, nil
+// Pairs returns a Lua function like `pairs` that also iterates over wrapped maps, slices and arrays,
+// using DefaultConverter.
+func Pairs(L *lua.LState) *lua.LFunction {
+	return DefaultConverter.Pairs(L)
+}
 
-	case reflect.Struct:
-		switch lv := lv.(type) {
-		case *lua.LTable:
-			aStruct, err := NewStructCoder(destType).TableToStruct(lv)
-			if err != nil {
-				return reflect.Value{}, err
+// Pairs returns a Lua function like `pairs` that also iterates over wrapped maps, slices and arrays,
+// as their `:pairs()` method does.  Since map entries take precedence over methods, an entry named
+// "pairs" hides that method; this function can't be hidden, and can replace the global `pairs`:
+//
+//	L.SetGlobal("pairs", conv.Pairs(L))
+//
+// Other values are passed to the global `pairs` as it was when Pairs was called.
+func (c *Converter) Pairs(L *lua.LState) *lua.LFunction {
+	builtin := L.GetGlobal("pairs")
+
+	return L.NewFunction(c.protect(func(L *lua.LState) int {
+		if ud, is := L.Get(1).(*lua.LUserData); is {
+			if rval, is := ud.Value.(reflect.Value); is {
+				switch reflect.Indirect(rval).Kind() {
+				case reflect.Map:
+					return c.mapPairs(L)
+				case reflect.Slice, reflect.Array:
+					return c.slicePairs(L)
+				}
 			}
-			return reflect.ValueOf(aStruct), nil
-
-		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
 		}
 
-	case reflect.Func:
-		switch lv := lv.(type) {
-		case *lua.LUserData:
-			return lv.Value.(reflect.Value), nil
-		default:
-			return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
-		}
+		L.Push(builtin)
+		L.Push(L.Get(1))
+		L.Call(1, 3)
+		return 3
+	}))
+}
 
-	default:
-		return reflect.Value{}, fmt.Errorf("luaconv.Unwrap: cannot convert %v to %v", lv.Type(), destType.String())
-	}
+// unwrapMapKey converts a Lua value into a key of a wrapped map, as with Unwrap, except that numbers
+// are always checked as in StrictNumbers mode, so that e.g. 1.5 never matches the key 1 of a
+// map[int]T.
+func (c *Converter) unwrapMapKey(L *lua.LState, lv lua.LValue, keyType reflect.Type) (rval reflect.Value, err error) {
+	defer c.recoverPanic("Unwrap", &err)
+
+	d := c.newDecoder(L, "Unwrap")
+	d.strictNumbers = true
+	d.wrapFuncs = true
+	rval, err = d.decode(lv, keyType)
+	return rval, d.result(err)
 }
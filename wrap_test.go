@@ -1,6 +1,8 @@
 package luaconv_test
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
 
 	. "github.com/onsi/ginkgo"
@@ -20,6 +22,16 @@ func (sl StringSlice) Set(idx int, s string) {
 	sl[idx] = s
 }
 
+type Scores map[string]int
+
+func (s Scores) Total() int {
+	total := 0
+	for _, score := range s {
+		total += score
+	}
+	return total
+}
+
 type blah struct {
 	name  string
 	Color int32
@@ -104,6 +116,532 @@ var _ = Describe("Wrap", func() {
 		})
 	})
 
+	Context("when given a slice", func() {
+		It("should support appending, inserting, removing and slicing from Lua", func() {
+			names := []string{"a", "b"}
+
+			luaNames, err := luaconv.Wrap(L, reflect.ValueOf(&names))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("names", luaNames)
+
+			err = L.DoString(`
+                names[#names + 1] = 'c'
+                names:append('d', 'e')
+                names:insert(1, 'z')
+                names:insert('f')
+                assert(#names == 7)
+
+                assert(names:remove(1) == 'z')
+                assert(names:remove() == 'f')
+
+                local sub = names:slice(2, 3)
+                assert(#sub == 2 and sub[1] == 'b' and sub[2] == 'c')
+
+                local t = names:totable()
+                assert(type(t) == 'table' and #t == 5 and t[5] == 'e')
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(names).To(Equal([]string{"a", "b", "c", "d", "e"}))
+		})
+
+		It("should only grow the userdata's copy when the slice was not wrapped via a pointer", func() {
+			names := make([]string, 1, 1)
+
+			luaNames, err := luaconv.Wrap(L, reflect.ValueOf(names))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			var got []string
+			luafn, err := luaconv.Wrap(L, reflect.ValueOf(func(s []string) { got = s }))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("names", luaNames)
+			L.SetGlobal("thefunc", luafn)
+
+			err = L.DoString(`
+                names[1] = 'a'
+                names:append('b')
+                assert(#names == 2)
+                thefunc(names)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(names).To(Equal([]string{"a"}))
+			Expect(got).To(Equal([]string{"a", "b"}))
+		})
+
+		It("should grow a slice field of a struct wrapped via a pointer", func() {
+			type Holder struct {
+				V []int
+			}
+
+			holder := &Holder{V: []int{1}}
+
+			luaHolder, err := luaconv.Wrap(L, reflect.ValueOf(holder))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("holder", luaHolder)
+
+			err = L.DoString(`
+                holder.V:append(2)
+                holder.V[#holder.V + 1] = 3
+                holder.V:insert(1, 0)
+                assert(#holder.V == 4)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(holder.V).To(Equal([]int{0, 1, 2, 3}))
+		})
+
+		It("should raise a Lua error for out-of-range positions", func() {
+			luaNames, err := luaconv.Wrap(L, reflect.ValueOf([]string{"a"}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("names", luaNames)
+
+			Expect(L.DoString(`names:insert(3, 'x')`)).To(HaveOccurred())
+			Expect(L.DoString(`names:remove(2)`)).To(HaveOccurred())
+			Expect(L.DoString(`names:slice(1, 2)`)).To(HaveOccurred())
+			Expect(L.DoString(`names[3] = 'x'`)).To(HaveOccurred())
+		})
+
+		It("should raise a Lua error for fractional positions", func() {
+			names := []string{"a", "b"}
+			luaNames, err := luaconv.Wrap(L, reflect.ValueOf(&names))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("names", luaNames)
+
+			Expect(L.DoString(`names:insert(1.5, 'x')`)).To(HaveOccurred())
+			Expect(L.DoString(`names:remove(1.5)`)).To(HaveOccurred())
+			Expect(L.DoString(`names:slice(1.5)`)).To(HaveOccurred())
+			Expect(L.DoString(`names:slice(1, 1.5)`)).To(HaveOccurred())
+			Expect(names).To(Equal([]string{"a", "b"}))
+		})
+
+		It("should count negative positions from the end if NegativeIndexing is set", func() {
+			conv := luaconv.NewConverter()
+			conv.NegativeIndexing = true
+
+			names := []string{"a", "b", "c"}
+			luaNames, err := conv.Wrap(L, reflect.ValueOf(&names))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("names", luaNames)
+
+			err = L.DoString(`
+                local sub = names:slice(-2)
+                assert(#sub == 2 and sub[1] == 'b' and sub[2] == 'c')
+                assert(names:remove(-3) == 'a')
+                names:insert(-1, 'x')
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(names).To(Equal([]string{"b", "x", "c"}))
+			Expect(L.DoString(`names:remove(-4)`)).To(HaveOccurred())
+		})
+	})
+
+	Context("when setting an element of an array wrapped by value", func() {
+		It("should raise a Lua error", func() {
+			luaNums, err := luaconv.Wrap(L, reflect.ValueOf([2]int{1, 2}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("nums", luaNums)
+
+			err = L.DoString(`nums[1] = 5`)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cannot set index 1 of Go type [2]int (wrap a pointer to make it settable)"))
+			Expect(err.Error()).NotTo(ContainSubstring("panic"))
+		})
+	})
+
+	Context("when indexing a slice or array with an invalid index", func() {
+		It("should read nil, and raise a Lua error on writes", func() {
+			luaNames, err := luaconv.Wrap(L, reflect.ValueOf([]string{"a", "b"}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("names", luaNames)
+
+			err = L.DoString(`
+                assert(names[0] == nil)
+                assert(names[-1] == nil)
+                assert(names[1.5] == nil)
+                assert(names[3] == nil)
+                assert(names[2] == 'b')
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(L.DoString(`names[0] = 'x'`)).To(HaveOccurred())
+			Expect(L.DoString(`names[-1] = 'x'`)).To(HaveOccurred())
+			Expect(L.DoString(`names[1.5] = 'x'`)).To(HaveOccurred())
+		})
+
+		It("should raise a Lua error on reads if StrictIndexing is set", func() {
+			conv := luaconv.NewConverter()
+			conv.StrictIndexing = true
+
+			luaNames, err := conv.Wrap(L, reflect.ValueOf([]string{"a", "b"}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("names", luaNames)
+
+			err = L.DoString(`local x = names[3]`)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("slice index 3 out of range (length 2)"))
+
+			Expect(L.DoString(`local x = names[0]`)).To(HaveOccurred())
+		})
+
+		It("should count negative indices from the end if NegativeIndexing is set", func() {
+			conv := luaconv.NewConverter()
+			conv.NegativeIndexing = true
+
+			names := [3]string{"a", "b", "c"}
+			luaNames, err := conv.Wrap(L, reflect.ValueOf(&names))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("names", luaNames)
+
+			err = L.DoString(`
+                assert(names[-1] == 'c')
+                assert(names[-3] == 'a')
+                assert(names[-4] == nil)
+                names[-2] = 'x'
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(names).To(Equal([3]string{"a", "x", "c"}))
+		})
+	})
+
+	Context("when given a map", func() {
+		It("should convert Lua keys to the map's key type", func() {
+			type Code string
+
+			ints := map[int]string{1: "one", 2: "two"}
+			codes := map[Code]int{"abc": 123}
+
+			luaInts, err := luaconv.Wrap(L, reflect.ValueOf(ints))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			luaCodes, err := luaconv.Wrap(L, reflect.ValueOf(codes))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("ints", luaInts)
+			L.SetGlobal("codes", luaCodes)
+
+			err = L.DoString(`
+                assert(ints[1] == 'one')
+                assert(ints[3] == nil)
+                ints[3] = 'three'
+
+                assert(codes.abc == 123)
+                assert(codes.xyz == nil)
+                codes.xyz = 456
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(ints[3]).To(Equal("three"))
+			Expect(codes["xyz"]).To(Equal(456))
+		})
+
+		It("should not truncate or coerce keys that aren't valid for the map's key type", func() {
+			ints := map[int]string{1: "one"}
+
+			luaInts, err := luaconv.Wrap(L, reflect.ValueOf(ints))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("ints", luaInts)
+
+			err = L.DoString(`
+                assert(ints[1.5] == nil)
+                assert(ints[2^70] == nil)
+                assert(ints.foo == nil)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(L.DoString(`ints[1.5] = 'x'`)).To(HaveOccurred())
+			Expect(ints).To(Equal(map[int]string{1: "one"}))
+		})
+
+		It("should access the map through a pointer to it", func() {
+			m := map[string]int{"a": 1}
+			var empty map[string]int
+
+			luaMap, err := luaconv.Wrap(L, reflect.ValueOf(&m))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			luaEmpty, err := luaconv.Wrap(L, reflect.ValueOf(&empty))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("m", luaMap)
+			L.SetGlobal("empty", luaEmpty)
+
+			err = L.DoString(`
+                assert(m.a == 1)
+                m.b = 2
+                assert(#m == 2)
+
+                local n = 0
+                for k, v in m:pairs() do
+                    n = n + v
+                end
+                assert(n == 3)
+
+                assert(empty.a == nil)
+                empty.a = 1
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(m).To(Equal(map[string]int{"a": 1, "b": 2}))
+			Expect(empty).To(Equal(map[string]int{"a": 1}))
+		})
+
+		It("should delete entries that are assigned nil", func() {
+			m := map[string]int{"a": 1, "b": 2}
+
+			luaMap, err := luaconv.Wrap(L, reflect.ValueOf(m))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("m", luaMap)
+
+			err = L.DoString(`
+                m.a = nil
+                assert(#m == 1)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(m).To(Equal(map[string]int{"b": 2}))
+		})
+
+		It("should expose the map's methods", func() {
+			luaMap, err := luaconv.Wrap(L, reflect.ValueOf(Scores{"a": 1, "b": 2}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("scores", luaMap)
+
+			err = L.DoString(`assert(scores:Total() == 3)`)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+	})
+
+	Context("when iterating over a wrapped slice, array or map", func() {
+		It("should support :ipairs() and :pairs() on slices and arrays", func() {
+			slice, err := luaconv.Wrap(L, reflect.ValueOf(StringSlice{"a", "b", "c"}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			array, err := luaconv.Wrap(L, reflect.ValueOf([2]int{10, 20}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("slice", slice)
+			L.SetGlobal("array", array)
+
+			err = L.DoString(`
+                local joined = ''
+                for i, s in slice:ipairs() do
+                    joined = joined .. i .. s
+                end
+                assert(joined == '1a2b3c', joined)
+
+                local sum = 0
+                for _, n in array:pairs() do
+                    sum = sum + n
+                end
+                assert(sum == 30)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+
+		It("should end iteration when given an invalid control value", func() {
+			slice, err := luaconv.Wrap(L, reflect.ValueOf([]string{"a", "b"}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("slice", slice)
+
+			err = L.DoString(`
+                local next = slice:ipairs()
+                assert(next(slice, -1) == nil)
+                assert(next(slice, 2) == nil)
+                assert(next(slice, 1) == 2)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+
+		It("should iterate over slices and arrays wrapped via a pointer", func() {
+			names := []string{"a", "b"}
+			slice, err := luaconv.Wrap(L, reflect.ValueOf(&names))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			nums := [2]int{10, 20}
+			array, err := luaconv.Wrap(L, reflect.ValueOf(&nums))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("slice", slice)
+			L.SetGlobal("array", array)
+
+			err = L.DoString(`
+                local joined = ''
+                for i, s in slice:ipairs() do
+                    joined = joined .. i .. s
+                end
+                assert(joined == '1a2b', joined)
+
+                local sum = 0
+                for _, n in array:pairs() do
+                    sum = sum + n
+                end
+                assert(sum == 30)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+
+		It("should support :pairs() on maps, in sorted order if SortMapKeys is set", func() {
+			conv := luaconv.NewConverter()
+			conv.SortMapKeys = true
+
+			m, err := conv.Wrap(L, reflect.ValueOf(map[string]int{"c": 3, "a": 1, "b": 2}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("m", m)
+
+			err = L.DoString(`
+                local joined = ''
+                for k, v in m:pairs() do
+                    joined = joined .. k .. v
+                end
+                assert(joined == 'a1b2c3', joined)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+
+		It("should still look up map entries that are named like builtin methods", func() {
+			m, err := luaconv.Wrap(L, reflect.ValueOf(map[string]int{"pairs": 1}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("m", m)
+
+			err = L.DoString(`assert(m.pairs == 1)`)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+
+		It("should iterate over wrapped values and tables with Pairs, even if an entry is named pairs", func() {
+			m, err := luaconv.Wrap(L, reflect.ValueOf(map[string]int{"pairs": 1, "b": 2}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			slice, err := luaconv.Wrap(L, reflect.ValueOf([]int{10, 20}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("m", m)
+			L.SetGlobal("slice", slice)
+			L.SetGlobal("pairs", luaconv.Pairs(L))
+
+			err = L.DoString(`
+                local sum = 0
+                for _, v in pairs(m) do sum = sum + v end
+                assert(sum == 3, sum)
+
+                for _, v in pairs(slice) do sum = sum + v end
+                assert(sum == 33, sum)
+
+                for _, v in pairs({100}) do sum = sum + v end
+                assert(sum == 133, sum)
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(L.DoString(`m:pairs()`)).To(HaveOccurred())
+		})
+	})
+
 	Context("when given a struct with methods", func() {
 		It("should return a Lua userdata value with the struct's methods in its metatable", func() {
 			val := &blah{"foo", 123}
@@ -166,6 +704,46 @@ var _ = Describe("Wrap", func() {
 		})
 	})
 
+	Context("when given a complex number", func() {
+		It("should support arithmetic, comparison and field access from Lua", func() {
+			a, err := luaconv.Wrap(L, reflect.ValueOf(complex(1, 2)))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			b, err := luaconv.Wrap(L, reflect.ValueOf(complex(3, -1)))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("a", a)
+			L.SetGlobal("b", b)
+
+			err = L.DoString(`
+                local sum = a + b
+                assert(sum.re == 4 and sum.im == 1)
+                assert(a - b == -b + a)
+                assert((a * b).re == 5 and (a * b).im == 5)
+                assert((a * 2).im == 4)
+                assert((1 + a).re == 2)
+                assert((a / a).re == 1 and (a / a).im == 0)
+                assert(a ~= b)
+                assert(tostring(a) == '(1+2i)')
+
+                result = a * b
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			nv, err := luaconv.Unwrap(L, L.GetGlobal("result"), reflect.TypeOf(complex128(0)))
+			if err != nil {
+				Fail(err.Error())
+			}
+			Expect(nv.Interface()).To(Equal(complex(5, 5)))
+		})
+	})
+
 	Context("when given a function", func() {
 		It("should wrap that function in a closure that unwraps all of the function's arguments to the appropriate Go types and wraps the return value(s) as Lua types", func() {
 			var gotStr string
@@ -196,5 +774,256 @@ var _ = Describe("Wrap", func() {
 			Expect(gotStr).To(Equal("foo"))
 			Expect(gotInt).To(Equal(123))
 		})
+
+		It("should collect trailing Lua args into the variadic parameter of a variadic function", func() {
+			var gotFormat string
+			var gotArgs []interface{}
+			luafn, err := luaconv.Wrap(L, reflect.ValueOf(func(format string, args ...interface{}) string {
+				gotFormat = format
+				gotArgs = args
+				return fmt.Sprintf(format, args...)
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("sprintf", luafn)
+
+			err = L.DoString(`
+                assert(sprintf('%v-%v', 'foo', 'bar') == 'foo-bar')
+                assert(sprintf('none') == 'none')
+            `)
+
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(gotFormat).To(Equal("none"))
+			Expect(gotArgs).To(BeEmpty())
+		})
+
+		It("should convert Lua functions passed as arguments into Go functions of the parameter's type", func() {
+			type Event struct {
+				Name string
+			}
+
+			var handled []bool
+			luafn, err := luaconv.Wrap(L, reflect.ValueOf(func(handler func(Event) bool) {
+				handled = append(handled, handler(Event{Name: "click"}))
+				handled = append(handled, handler(Event{Name: "scroll"}))
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("subscribe", luafn)
+
+			err = L.DoString(`
+                subscribe(function(evt)
+                    return evt.Name == 'click'
+                end)
+            `)
+
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(handled).To(Equal([]bool{true, false}))
+		})
+
+		It("should pass the zero value for nil arguments", func() {
+			var gotInt int
+			var gotNames []string
+			var gotArgs []interface{}
+			luafn, err := luaconv.Wrap(L, reflect.ValueOf(func(i int, names []string, args ...interface{}) {
+				gotInt = i
+				gotNames = names
+				gotArgs = args
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("thefunc", luafn)
+
+			err = L.DoString(`thefunc(nil, nil, 'foo', nil)`)
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			Expect(gotInt).To(Equal(0))
+			Expect(gotNames).To(BeNil())
+			Expect(gotArgs).To(Equal([]interface{}{"foo", nil}))
+		})
+
+		It("should convert byte slices to and from Lua strings", func() {
+			luafn, err := luaconv.Wrap(L, reflect.ValueOf(func(data []byte) []byte {
+				out := make([]byte, len(data))
+				for i := range data {
+					out[i] = ^data[i]
+				}
+				return out
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("invert", luafn)
+
+			err = L.DoString(`assert(invert('\0\255') == '\255\0')`)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+
+		Context("that returns a non-nil error", func() {
+			failing := func(fail bool) (int, error) {
+				if fail {
+					return 0, errors.New("boom")
+				}
+				return 5, nil
+			}
+
+			It("should return nil, the error message and the wrapped error by default", func() {
+				luafn, err := luaconv.Wrap(L, reflect.ValueOf(failing))
+				if err != nil {
+					Fail(err.Error())
+				}
+
+				L.SetGlobal("thefunc", luafn)
+
+				err = L.DoString(`
+                    local val, msg, goerr = thefunc(true)
+                    assert(val == nil)
+                    assert(msg == 'boom')
+                    assert(goerr:Error() == 'boom')
+                    assert(tostring(goerr) == 'boom')
+
+                    val, msg = thefunc(false)
+                    assert(val == 5)
+                    assert(msg == nil)
+                `)
+
+				if err != nil {
+					Fail(err.Error())
+				}
+			})
+
+			It("should raise a Lua error when the Converter's ErrorMode is ErrorModeRaise", func() {
+				conv := luaconv.NewConverter()
+				conv.ErrorMode = luaconv.ErrorModeRaise
+
+				luafn, err := conv.Wrap(L, reflect.ValueOf(failing))
+				if err != nil {
+					Fail(err.Error())
+				}
+
+				L.SetGlobal("thefunc", luafn)
+
+				err = L.DoString(`
+                    local ok, msg = pcall(thefunc, true)
+                    assert(not ok)
+                    assert(string.find(msg, 'boom'))
+
+                    assert(thefunc(false) == 5)
+                `)
+
+				if err != nil {
+					Fail(err.Error())
+				}
+			})
+		})
+
+		It("should raise a Lua error when a variadic function is called with too few args", func() {
+			luafn, err := luaconv.Wrap(L, reflect.ValueOf(func(a int, rest ...int) int {
+				return a + len(rest)
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("thefunc", luafn)
+
+			err = L.DoString(`
+                assert(thefunc(1, 2, 3) == 3)
+            `)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = L.DoString(`thefunc()`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when Go code panics during a call from Lua", func() {
+		It("should raise a Lua error instead of crashing", func() {
+			luafn, err := luaconv.Wrap(L, reflect.ValueOf(func(names []string) string {
+				return names[5]
+			}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("thefunc", luafn)
+
+			err = L.DoString(`
+                local ok, msg = pcall(thefunc, {'a'})
+                assert(not ok)
+                assert(string.find(msg, 'panic: runtime error: index out of range'))
+                assert(not string.find(msg, 'goroutine'))
+            `)
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+
+		It("should include the Go stack trace if PanicStackTraces is set", func() {
+			conv := luaconv.NewConverter()
+			conv.PanicStackTraces = true
+
+			luafn, err := conv.Wrap(L, reflect.ValueOf(func() { panic("boom") }))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("thefunc", luafn)
+
+			err = L.DoString(`thefunc()`)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("panic: boom"))
+			Expect(err.Error()).To(ContainSubstring("goroutine"))
+		})
+
+		It("should raise a Lua error when setting a field of an unaddressable struct", func() {
+			ud, err := luaconv.Wrap(L, reflect.ValueOf(blah{"foo", 123}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			L.SetGlobal("val", ud)
+
+			err = L.DoString(`val.Color = 456`)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cannot set field 'Color'"))
+
+			err = L.DoString(`val.Nope = 456`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should raise a Lua error when a metamethod is given foreign userdata", func() {
+			ud, err := luaconv.Wrap(L, reflect.ValueOf(StringSlice{"a"}))
+			if err != nil {
+				Fail(err.Error())
+			}
+
+			foreign := L.NewUserData()
+			foreign.Value = "not a reflect.Value"
+
+			L.SetGlobal("val", ud)
+			L.SetGlobal("foreign", foreign)
+
+			err = L.DoString(`getmetatable(val).__len(foreign)`)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("wrapped Go value expected"))
+		})
 	})
 })